@@ -0,0 +1,153 @@
+package spg
+
+import (
+	"math"
+	"math/big"
+	"strings"
+	"sync"
+)
+
+/*** Compiled character recipes
+
+	A CharRecipe is just settings. Each call to Generate, Entropy, or
+	Alphabet works out the alphabet and required sets from them again, and
+	counting the passwords a recipe can produce can be expensive.
+
+	Compile does all of that once. The CompiledCharRecipe it returns keeps its
+	own copy of the recipe, so changing the CharRecipe afterwards has no
+	effect on it, and it is safe to use from many goroutines at once.
+
+***/
+
+// CompiledCharRecipe is a CharRecipe that has been checked and worked out,
+// ready for generating many passwords. Create one with CharRecipe.Compile.
+type CompiledCharRecipe struct {
+	r       CharRecipe // our own copy, with the computed sets filled in
+	abc     charList
+	entropy float32
+	trials  int      // how many times to try rejection sampling before picking directly
+	total   *big.Int // number of passwords r can produce, if it needs counting
+
+	// The walker remembers counts as it goes, so it can only be used by one
+	// goroutine at a time
+	mu     sync.Mutex
+	walker *charWalker
+}
+
+// clone returns a copy of r that shares nothing that can be changed with r
+func (r CharRecipe) clone() CharRecipe {
+	c := r
+	c.RequireSets = append([]string(nil), r.RequireSets...)
+	c.SetCounts = append([]CountRange(nil), r.SetCounts...)
+	c.Positions = append([]PositionRule(nil), r.Positions...)
+	if r.ClassCounts != nil {
+		c.ClassCounts = make(map[CTFlag]CountRange, len(r.ClassCounts))
+		for f, cr := range r.ClassCounts {
+			c.ClassCounts[f] = cr
+		}
+	}
+	c.allowedSet, c.requiredSets, c.positionSets = nil, nil, nil
+	return c
+}
+
+// Compile checks r and works out everything needed to generate passwords
+// from it. If r can't produce passwords, the error is what Validate says.
+func (r CharRecipe) Compile() (*CompiledCharRecipe, error) {
+	c := &CompiledCharRecipe{r: r.clone()}
+	cr := &c.r
+	if cr.Length < 1 || cr.runsError() != nil {
+		return nil, cr.Validate()
+	}
+	c.abc = cr.buildCharacterList()
+	if len(c.abc) == 0 || cr.overlapError() != nil {
+		return nil, cr.Validate()
+	}
+
+	// Without anything to count, any string from the alphabet will do
+	if !cr.needsCounting() {
+		c.entropy = float32(entropySimple(cr.Length, len(c.abc)))
+		c.trials = 1
+		return c, nil
+	}
+
+	c.walker = newCharWalker(cr, c.abc)
+	c.total = c.walker.total()
+	if c.total.Sign() == 0 {
+		return nil, cr.Validate()
+	}
+	c.entropy = log2Big(c.total)
+
+	// Rejection sampling is only worth trying if it is unlikely to fail
+	// every one of the trials allowed
+	all := new(big.Int).Exp(toBigInt(len(c.abc)), toBigInt(cr.Length), nil)
+	sp, _ := new(big.Rat).SetFrac(c.total, all).Float64()
+	trials, failRate := cr.trialLimits()
+	if math.Pow(1.0-sp, float64(trials)) <= failRate {
+		c.trials = trials
+	}
+	return c, nil
+}
+
+// Recipe returns a copy of the recipe that c was compiled from
+func (c *CompiledCharRecipe) Recipe() CharRecipe {
+	return c.r.clone()
+}
+
+// Entropy returns the entropy of passwords from c
+func (c *CompiledCharRecipe) Entropy() float32 {
+	return c.entropy
+}
+
+// Alphabet returns a sorted string of the characters that passwords from c are made of
+func (c *CompiledCharRecipe) Alphabet() string {
+	return strings.Join(c.abc, "")
+}
+
+// Generate a password from c, with randomness from the Source of the recipe
+func (c *CompiledCharRecipe) Generate() (*Password, error) {
+	return c.GenerateFrom(c.r.Source)
+}
+
+// GenerateFrom is like Generate, but draws its randomness from rs.
+// A nil rs means crypto/rand.
+func (c *CompiledCharRecipe) GenerateFrom(rs RandomSource) (*Password, error) {
+	p := &Password{Entropy: c.entropy}
+
+	// Generating from the whole alphabet and rejecting what doesn't meet the
+	// requirements is fast when it is likely to succeed in a few trials.
+	for i := 0; i < c.trials; i++ {
+		tokens := make(Tokens, c.r.Length)
+		for j := range tokens {
+			k, err := randomUint32n(rs, uint32(len(c.abc)))
+			if err != nil {
+				return nil, err
+			}
+			tokens[j] = Token{c.abc[k], AtomType}
+		}
+		p.tokens = tokens
+		if c.walker == nil || c.r.accepts(p.String()) {
+			return p, nil
+		}
+	}
+
+	// Otherwise (or if we were unlucky) we pick directly from the passwords
+	// that meet the requirements. Both methods are uniform over the same
+	// set of passwords, so mixing them does not change the distribution.
+	idx, err := randomBigIntn(rs, c.total)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tokens, err := c.walker.unrank(idx)
+	if err != nil {
+		return nil, err
+	}
+	p.tokens = tokens
+	return p, nil
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
@@ -0,0 +1,117 @@
+package spg
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	recipes := []CharRecipe{
+		*NewCharRecipe(20),
+		{Length: 8, Allow: Letters, Require: Digits | Symbols},
+		{Length: 6, RequireSets: []string{"a", "b", "c", "d", "e"}},
+		{Length: 10, Allow: Letters | Digits, MaxConsecutive: 1,
+			Positions: []PositionRule{{Position: 0, Allow: Uppers}}},
+	}
+	for i, r := range recipes {
+		c, err := r.Compile()
+		if err != nil {
+			t.Fatalf("%d: couldn't compile: %v", i, err)
+		}
+		if c.Entropy() != r.Entropy() {
+			t.Errorf("%d: compiled entropy %f, recipe entropy %f", i, c.Entropy(), r.Entropy())
+		}
+		if c.Alphabet() != r.Alphabet() {
+			t.Errorf("%d: compiled alphabet %q, recipe alphabet %q", i, c.Alphabet(), r.Alphabet())
+		}
+
+		// Compiled or not, the same source gives the same password
+		a, err := c.GenerateFrom(newDetSource("compile"))
+		if err != nil {
+			t.Fatalf("%d: failed to generate: %v", i, err)
+		}
+		b, err := r.GenerateFrom(newDetSource("compile"))
+		if err != nil {
+			t.Fatalf("%d: failed to generate: %v", i, err)
+		}
+		if a.String() != b.String() || a.Entropy != b.Entropy {
+			t.Errorf("%d: compiled gave %q, recipe gave %q", i, a, b)
+		}
+		if !meetsRecipe(r, a.String()) {
+			t.Errorf("%d: %q doesn't meet the recipe", i, a)
+		}
+	}
+
+	// Problems are found when compiling
+	bad := CharRecipe{Length: 2, RequireSets: []string{"a", "b", "c"}}
+	if _, err := bad.Compile(); !errors.Is(err, ErrImpossibleRequirements) || err.Error() != bad.Validate().Error() {
+		t.Errorf("compiling should fail like Validate, got %v", err)
+	}
+}
+
+func TestCompiledIsImmutable(t *testing.T) {
+	r := CharRecipe{Length: 12, Allow: Lowers, RequireSets: []string{"0123"},
+		ClassCounts: map[CTFlag]CountRange{Lowers: {Max: 8}}}
+	c, err := r.Compile()
+	if err != nil {
+		t.Fatalf("couldn't compile: %v", err)
+	}
+	ent := c.Entropy()
+
+	r.RequireSets[0] = "!"
+	r.ClassCounts[Lowers] = CountRange{Max: 1}
+	r.Length = 3
+	p, err := c.Generate()
+	if err != nil {
+		t.Fatalf("failed to generate: %v", err)
+	}
+	if len(p.String()) != 12 || strings.Contains(p.String(), "!") || c.Entropy() != ent {
+		t.Errorf("changing the recipe changed the compiled recipe: %q", p)
+	}
+	if got := c.Recipe(); got.RequireSets[0] != "0123" || got.Length != 12 {
+		t.Errorf("Recipe should be what was compiled, got %+v", got)
+	}
+}
+
+func TestCompiledConcurrent(t *testing.T) {
+	// Unlikely enough to meet by chance that generation walks the counts
+	r := CharRecipe{Length: 8, Allow: Letters, RequireSets: []string{"a", "b", "c", "d"}, MaxConsecutive: 2}
+	c, err := r.Compile()
+	if err != nil {
+		t.Fatalf("couldn't compile: %v", err)
+	}
+	if c.trials != 0 {
+		t.Fatal("this test expects rejection sampling not to be used")
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 80)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				p, err := c.Generate()
+				if err != nil {
+					errs <- err.Error()
+					return
+				}
+				if !meetsRecipe(r, p.String()) {
+					errs <- p.String() + " doesn't meet the recipe"
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for e := range errs {
+		t.Error(e)
+	}
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
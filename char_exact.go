@@ -0,0 +1,572 @@
+package spg
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	set "github.com/deckarep/golang-set"
+)
+
+/*** Exact (rejection free) generation of character passwords
+
+	When there are required sets, Generate will usually make candidate
+	passwords from the whole alphabet and throw away those that fail
+	requireFilter. That is fast and uniform, but when the chance of success
+	is small it may never finish.
+
+	Instead we can walk through the password one position at a time. For each
+	candidate character we know how many valid passwords start with the prefix
+	built so far plus that character (that is just n() for what remains).
+	Lining up all valid passwords in alphabetical order, the i-th one can be
+	found by skipping over whole blocks of passwords that share a prefix.
+	Picking i uniformly from [0, n) then gives a uniformly chosen password,
+	exactly the same distribution as the rejection method, with no retries.
+
+***/
+
+// countMethod is how a charWalker counts the ways to complete a password
+type countMethod int
+
+const (
+	countAtLeastOne countMethod = iota // Only "at least one" requirements: inclusion-exclusion, n()
+	countDisjoint                      // Bounds on sets that don't overlap: boundedCount()
+	countByPosition                    // Anything else: position by position through atoms
+)
+
+// charWalker finds passwords by their position in the (sorted) list of all
+// passwords a recipe can produce. It must be created from a recipe
+// on which buildCharacterList has already been run.
+//
+// What it needs to know about a prefix of a password is in a walkState.
+// Counts are capped once they reach what a set needs, as more make
+// no difference, unless the set also has a maximum.
+type charWalker struct {
+	r         *CharRecipe
+	abc       charList
+	method    countMethod
+	atoms     []charAtom
+	atomOf    map[string]int        // which atom each character of abc is in
+	trackLast bool                  // whether the previous character matters
+	memo      map[string]*big.Int   // completions by position and state
+	coefs     map[string][]*big.Int // avoidCoefs by which required sets are still unmet
+}
+
+// charAtom is a group of characters in the alphabet that are in
+// exactly the same required sets, and may appear at exactly the same
+// positions, and so are interchangeable for counting
+type charAtom struct {
+	size int
+	char string // the first character of the atom
+	in   []bool // in[i] is true if the characters are in the i-th required set
+	at   []bool // at[pos] is true if the characters may appear at pos
+}
+
+// walkState is what matters about a prefix of a password for how it may be completed
+type walkState struct {
+	counts []int // characters from each required set
+	last   int   // atom of the previous character, or -1 if it doesn't matter
+	run    int   // how many times in a row the previous character has appeared
+	seq    int   // length of the ascending or descending run ending with the previous character
+	dir    int   // 1 if that run is ascending, -1 if descending
+	used   []int // for NoRepeat, how many characters of each atom have been used
+}
+
+// key identifies s at pos for memoization
+func (s walkState) key(pos int) string {
+	b := make([]byte, 0, 32)
+	b = strconv.AppendInt(b, int64(pos), 10)
+	b = append(b, ':')
+	for _, c := range s.counts {
+		b = strconv.AppendInt(b, int64(c), 10)
+		b = append(b, ',')
+	}
+	for _, v := range []int{s.last, s.run, s.seq, s.dir} {
+		b = append(b, '|')
+		b = strconv.AppendInt(b, int64(v), 10)
+	}
+	b = append(b, '|')
+	for _, u := range s.used {
+		b = strconv.AppendInt(b, int64(u), 10)
+		b = append(b, ',')
+	}
+	return string(b)
+}
+
+func newCharWalker(r *CharRecipe, abc charList) *charWalker {
+	w := &charWalker{
+		r:     r,
+		abc:   abc,
+		memo:  make(map[string]*big.Int),
+		coefs: make(map[string][]*big.Int),
+	}
+
+	w.method = r.walkMethod()
+	// With NoRepeat nothing can be repeated, so runs of
+	// the same character can't happen
+	w.trackLast = !r.NoRepeat && (r.MaxConsecutive > 0 || r.MaxSequence > 0)
+	w.buildAtoms()
+	return w
+}
+
+// walkMethod is how a charWalker counts the passwords from r.
+// buildCharacterList must already have been run on r.
+func (r *CharRecipe) walkMethod() countMethod {
+	method := countAtLeastOne
+	for _, rs := range r.requiredSets {
+		if rs.min != 1 || rs.max != 0 {
+			method = countDisjoint
+		}
+	}
+	if r.positionSets != nil || r.limitsRuns() {
+		return countByPosition
+	}
+	if method == countDisjoint {
+		sets := r.requiredSets.sets()
+		if len(overlapGroups(sets)) < len(sets) {
+			return countByPosition
+		}
+	}
+	return method
+}
+
+// Counting goes through every combination of the required sets in a group
+// that share characters, so such groups can't be allowed to grow too big.
+// Counting position by position keeps track of those combinations at every
+// position, so there the groups must be smaller still.
+const (
+	maxOverlappingSets           = 16
+	maxOverlappingSetsByPosition = 8
+)
+
+// overlapError reports groups of overlapping required sets that are too big
+// for us to count. buildCharacterList must already have been run on r.
+func (r *CharRecipe) overlapError() error {
+	limit := maxOverlappingSets
+	if r.walkMethod() != countAtLeastOne {
+		limit = maxOverlappingSetsByPosition
+	}
+	for _, group := range overlapGroups(r.requiredSets.sets()) {
+		if len(group) > limit {
+			return &RecipeError{Problem: ProblemUnsupported,
+				Detail: fmt.Sprintf("%d required sets share characters, but no more than %d may", len(group), limit)}
+		}
+	}
+	return nil
+}
+
+// buildAtoms groups the alphabet into atoms. Letters and digits
+// each get an atom of their own when ascending or descending runs are
+// limited, as then it matters exactly which character came before.
+func (w *charWalker) buildAtoms() {
+	w.atomOf = make(map[string]int)
+	bySig := make(map[string]int)
+	for _, c := range w.abc {
+		in := make([]bool, len(w.r.requiredSets))
+		for i, rs := range w.r.requiredSets {
+			in[i] = rs.s.Contains(c)
+		}
+		at := make([]bool, w.r.Length)
+		for pos := range at {
+			at[pos] = w.allowedAt(pos, c)
+		}
+		sig := fmt.Sprint(in, at)
+		if w.r.MaxSequence > 0 && inSequences(c) {
+			sig += c
+		}
+		if a, ok := bySig[sig]; ok {
+			w.atoms[a].size++
+			w.atomOf[c] = a
+			continue
+		}
+		bySig[sig] = len(w.atoms)
+		w.atomOf[c] = len(w.atoms)
+		w.atoms = append(w.atoms, charAtom{size: 1, char: c, in: in, at: at})
+	}
+}
+
+// allowedAt tells whether c may appear at position pos
+func (w *charWalker) allowedAt(pos int, c string) bool {
+	ps := w.r.positionSets
+	return ps == nil || ps[pos] == nil || ps[pos].Contains(c)
+}
+
+// add returns a copy of counts updated for a character in the sets marked in in.
+// It returns false if that would go over the maximum of any set.
+func (w *charWalker) add(counts []int, in []bool) ([]int, bool) {
+	next := make([]int, len(counts))
+	copy(next, counts)
+	for i, rs := range w.r.requiredSets {
+		if !in[i] {
+			continue
+		}
+		next[i]++
+		if rs.max > 0 {
+			if next[i] > rs.max {
+				return nil, false
+			}
+		} else if next[i] > rs.min {
+			next[i] = rs.min
+		}
+	}
+	return next, true
+}
+
+// start is the state of an empty password
+func (w *charWalker) start() walkState {
+	s := walkState{counts: make([]int, len(w.r.requiredSets)), last: -1}
+	if w.r.NoRepeat {
+		s.used = make([]int, len(w.atoms))
+	}
+	return s
+}
+
+// fresh is the state after adding a character from atom a to a prefix
+// with counts, when the character neither repeats the one before it
+// nor continues a run. It returns false if that would go over a maximum.
+func (w *charWalker) fresh(counts []int, a int) (walkState, bool) {
+	next, ok := w.add(counts, w.atoms[a].in)
+	if !ok {
+		return walkState{}, false
+	}
+	s := walkState{counts: next, last: -1}
+	if w.trackLast {
+		s.last, s.run, s.seq = a, 1, 1
+	}
+	return s, true
+}
+
+// step is the state after adding a character from atom a to s, where same
+// tells whether the character is the same as the previous one. It returns
+// false if the result breaks any of the rules of the recipe.
+func (w *charWalker) step(s walkState, a int, same bool) (walkState, bool) {
+	next, ok := w.fresh(s.counts, a)
+	if !ok {
+		return walkState{}, false
+	}
+	if w.r.NoRepeat {
+		if s.used[a] >= w.atoms[a].size {
+			return walkState{}, false
+		}
+		next.used = make([]int, len(s.used))
+		copy(next.used, s.used)
+		next.used[a]++
+	}
+	if !w.trackLast || s.last < 0 {
+		return next, true
+	}
+	if same && w.r.MaxConsecutive > 0 {
+		next.run = s.run + 1
+		if next.run > w.r.MaxConsecutive {
+			return walkState{}, false
+		}
+	}
+	if w.r.MaxSequence > 0 {
+		if d := seqStep(w.atoms[s.last].char, w.atoms[a].char); d != 0 {
+			next.dir, next.seq = d, 2
+			if d == s.dir {
+				next.seq = s.seq + 1
+			}
+			if next.seq > w.r.MaxSequence {
+				return walkState{}, false
+			}
+		}
+	}
+	return next, true
+}
+
+// stepChar is step for the character c at pos, following prev,
+// in a password that already has the characters in used
+func (w *charWalker) stepChar(pos int, s walkState, c, prev string, used map[string]bool) (walkState, bool) {
+	if !w.allowedAt(pos, c) || used[c] {
+		return walkState{}, false
+	}
+	return w.step(s, w.atomOf[c], c == prev)
+}
+
+// completions is the number of ways to finish a password whose first pos
+// characters leave it in state s.
+func (w *charWalker) completions(pos int, s walkState) *big.Int {
+	key := s.key(pos)
+	if c, ok := w.memo[key]; ok {
+		return c
+	}
+
+	var c *big.Int
+	remaining := w.r.Length - pos
+	switch w.method {
+	case countAtLeastOne:
+		c = countAvoiding(len(w.abc), w.unmetCoefs(s.counts), remaining)
+
+	case countDisjoint:
+		// What is left of each set's bounds for the rest of the password
+		free := len(w.abc)
+		classes := make([]classBound, len(w.r.requiredSets))
+		for i, rs := range w.r.requiredSets {
+			free -= rs.size()
+			classes[i] = classBound{size: rs.size(), lo: rs.min - s.counts[i], hi: -1}
+			if classes[i].lo < 0 {
+				classes[i].lo = 0
+			}
+			if rs.max > 0 {
+				classes[i].hi = rs.max - s.counts[i]
+			}
+		}
+		c = boundedCount(free, classes, remaining)
+
+	default:
+		c = new(big.Int)
+		if remaining == 0 {
+			for i, rs := range w.r.requiredSets {
+				if s.counts[i] < rs.min {
+					w.memo[key] = c
+					return c
+				}
+			}
+			c.SetInt64(1)
+			break
+		}
+		if w.r.NoRepeat {
+			// Any of the unused characters of an atom will do
+			term := new(big.Int)
+			for a, atom := range w.atoms {
+				if !atom.at[pos] {
+					continue
+				}
+				if next, ok := w.step(s, a, false); ok {
+					term.Mul(big.NewInt(int64(atom.size-s.used[a])), w.completions(pos+1, next))
+					c.Add(c, term)
+				}
+			}
+			break
+		}
+		c.Set(w.anyNext(pos, s.counts))
+		if w.trackLast && s.last >= 0 {
+			// anyNext treats every character as fresh, so correct it for the
+			// (at most three) characters that repeat the previous one or
+			// continue a run from it
+			for _, ex := range w.exceptions(s.last) {
+				if !w.atoms[ex.atom].at[pos] {
+					continue
+				}
+				if g, ok := w.fresh(s.counts, ex.atom); ok {
+					c.Sub(c, w.completions(pos+1, g))
+				}
+				if next, ok := w.step(s, ex.atom, ex.same); ok {
+					c.Add(c, w.completions(pos+1, next))
+				}
+			}
+		}
+	}
+	w.memo[key] = c
+	return c
+}
+
+// unmetCoefs returns avoidCoefs for the required sets that counts
+// doesn't yet satisfy. Many states share them, and they are by far the
+// most expensive part of counting when required sets overlap.
+func (w *charWalker) unmetCoefs(counts []int) []*big.Int {
+	key := fmt.Sprint(counts)
+	if coef, ok := w.coefs[key]; ok {
+		return coef
+	}
+	// Sets already satisfied no longer constrain the rest of the password,
+	// even if they share characters with those that are not yet satisfied
+	required := make([]set.Set, 0, len(w.r.requiredSets))
+	for i, rs := range w.r.requiredSets {
+		if counts[i] == 0 {
+			required = append(required, rs.s)
+		}
+	}
+	coef := avoidCoefs(len(w.abc), required)
+	w.coefs[key] = coef
+	return coef
+}
+
+// anyNext is the number of ways to finish a password with counts from pos,
+// treating the next character as neither repeating the previous one nor
+// continuing a run. It doesn't depend on what that previous character was,
+// which saves going through the whole alphabet for each of them.
+func (w *charWalker) anyNext(pos int, counts []int) *big.Int {
+	key := "any" + walkState{counts: counts}.key(pos)
+	if c, ok := w.memo[key]; ok {
+		return c
+	}
+	c := new(big.Int)
+	term := new(big.Int)
+	for a, atom := range w.atoms {
+		if !atom.at[pos] {
+			continue
+		}
+		if next, ok := w.fresh(counts, a); ok {
+			term.Mul(big.NewInt(int64(atom.size)), w.completions(pos+1, next))
+			c.Add(c, term)
+		}
+	}
+	w.memo[key] = c
+	return c
+}
+
+// charException is a single character (from atom) which, following some
+// other character, can't be counted as fresh
+type charException struct {
+	atom int
+	same bool // it is the same character
+}
+
+// exceptions lists the characters that can't be counted as fresh after a
+// character from atom last
+func (w *charWalker) exceptions(last int) []charException {
+	var ex []charException
+	if w.r.MaxConsecutive > 0 {
+		ex = append(ex, charException{atom: last, same: true})
+	}
+	if w.r.MaxSequence > 0 {
+		for _, d := range []rune{-1, 1} {
+			c := seqNeighbor(w.atoms[last].char, d)
+			if a, ok := w.atomOf[c]; c != "" && ok {
+				ex = append(ex, charException{atom: a})
+			}
+		}
+	}
+	return ex
+}
+
+// total is the number of distinct passwords the recipe can produce
+func (w *charWalker) total() *big.Int {
+	return w.completions(0, w.start())
+}
+
+// unrank returns the idx-th (counting from 0) password, in alphabetical
+// order, of those that the recipe can produce. idx must be in [0, total()).
+func (w *charWalker) unrank(idx *big.Int) (Tokens, error) {
+	if idx.Sign() < 0 || idx.Cmp(w.total()) >= 0 {
+		return nil, fmt.Errorf("%w: %v", ErrIndexOutOfRange, idx)
+	}
+	rem := new(big.Int).Set(idx)
+	s := w.start()
+	prev := ""
+	used := make(map[string]bool)
+	tokens := make(Tokens, 0, w.r.Length)
+	for pos := 0; pos < w.r.Length; pos++ {
+		found := false
+		for _, c := range w.abc {
+			next, ok := w.stepChar(pos, s, c, prev, used)
+			if !ok {
+				continue
+			}
+			cnt := w.completions(pos+1, next)
+			if rem.Cmp(cnt) < 0 {
+				tokens = append(tokens, Token{c, AtomType})
+				s, prev = next, c
+				if w.r.NoRepeat {
+					used[c] = true
+				}
+				found = true
+				break
+			}
+			rem.Sub(rem, cnt)
+		}
+		if !found {
+			return nil, errCounting("ran out of characters at position %d", pos)
+		}
+	}
+	return tokens, nil
+}
+
+// rank is the inverse of unrank. It returns the position of the password
+// made of tokens in the alphabetical list of all the recipe can produce.
+func (w *charWalker) rank(tokens Tokens) (*big.Int, error) {
+	if len(tokens) != w.r.Length {
+		return nil, fmt.Errorf("%w: password has %d characters, recipe has %d", ErrNotFromRecipe, len(tokens), w.r.Length)
+	}
+	idx := new(big.Int)
+	s := w.start()
+	prev := ""
+	used := make(map[string]bool)
+	for pos, tok := range tokens {
+		found := false
+		for _, c := range w.abc {
+			next, ok := w.stepChar(pos, s, c, prev, used)
+			if c == tok.Value() {
+				if !w.allowedAt(pos, c) {
+					return nil, fmt.Errorf("%w: %q is not allowed at position %d", ErrNotFromRecipe, c, pos)
+				}
+				if !ok {
+					return nil, fmt.Errorf("%w: password breaks the rules of the recipe at position %d", ErrNotFromRecipe, pos)
+				}
+				s, prev = next, c
+				if w.r.NoRepeat {
+					used[c] = true
+				}
+				found = true
+				break
+			}
+			if ok {
+				idx.Add(idx, w.completions(pos+1, next))
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %q is not in the alphabet of the recipe", ErrNotFromRecipe, tok.Value())
+		}
+	}
+	// Only passwords that meet all of the requirements are counted
+	if w.completions(len(tokens), s).Sign() == 0 {
+		return nil, fmt.Errorf("%w: password does not meet the requirements (%s)", ErrNotFromRecipe,
+			strings.Join(w.r.requiredSets.names(), ", "))
+	}
+	return idx, nil
+}
+
+// Rank returns the position of p among all of the passwords that r can produce,
+// in alphabetical order. It is a number in [0, n) where n is the number of such
+// passwords (so 2^r.Entropy()). Unrank is its inverse.
+//
+// Together they make a bijection between passwords and integers, which can be used
+// to store passwords compactly, to enumerate small spaces such as PINs, or to build
+// deterministic derivation on top of a recipe.
+func (r CharRecipe) Rank(p *Password) (*big.Int, error) {
+	if r.Length < 1 || r.runsError() != nil {
+		return nil, r.Validate()
+	}
+	if p == nil {
+		return nil, fmt.Errorf("%w: nil password", ErrNotFromRecipe)
+	}
+	chars := r.buildCharacterList()
+	if len(chars) == 0 {
+		return nil, r.Validate()
+	}
+	if err := r.overlapError(); err != nil {
+		return nil, err
+	}
+	return newCharWalker(&r, chars).rank(p.Tokens())
+}
+
+// Unrank returns the idx-th password (counting from 0), in alphabetical order,
+// of all of those r can produce. It is the inverse of Rank.
+func (r CharRecipe) Unrank(idx *big.Int) (*Password, error) {
+	if r.Length < 1 || r.runsError() != nil {
+		return nil, r.Validate()
+	}
+	if idx == nil {
+		return nil, fmt.Errorf("%w: nil index", ErrIndexOutOfRange)
+	}
+	chars := r.buildCharacterList()
+	if len(chars) == 0 {
+		return nil, r.Validate()
+	}
+	if err := r.overlapError(); err != nil {
+		return nil, err
+	}
+	tokens, err := newCharWalker(&r, chars).unrank(idx)
+	if err != nil {
+		return nil, err
+	}
+	return &Password{tokens: tokens, Entropy: r.Entropy()}, nil
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
@@ -0,0 +1,433 @@
+package spg
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestUnrankCoversAll(t *testing.T) {
+	r := &CharRecipe{Length: 3, RequireSets: []string{"ab", "12"}, AllowChars: "x"}
+	chars := r.buildCharacterList()
+	w := newCharWalker(r, chars)
+
+	// 5^3 - (3^3 + 3^3 - 1^3) = 125 - 53 = 72
+	total := w.total()
+	if total.Int64() != 72 {
+		t.Fatalf("expected 72 passwords, not %v", total)
+	}
+
+	seen := make(map[string]bool)
+	prev := ""
+	for i := int64(0); i < total.Int64(); i++ {
+		tokens, err := w.unrank(big.NewInt(i))
+		if err != nil {
+			t.Fatalf("couldn't unrank %d: %v", i, err)
+		}
+		pw := Password{tokens: tokens}.String()
+		if !requireFilter(pw, r.requiredSets) {
+			t.Errorf("%d-th password %q doesn't meet requirements", i, pw)
+		}
+		if seen[pw] {
+			t.Errorf("%q appeared twice", pw)
+		}
+		if pw <= prev {
+			t.Errorf("%q is not after %q", pw, prev)
+		}
+		seen[pw] = true
+		prev = pw
+	}
+
+	if _, err := w.unrank(total); err == nil {
+		t.Error("unrank should fail for index past the end")
+	}
+}
+
+func TestUnrankOverlapping(t *testing.T) {
+	r := &CharRecipe{Length: 2, RequireSets: []string{"abc", "cde"}}
+	chars := r.buildCharacterList()
+	w := newCharWalker(r, chars)
+	total := w.total().Int64()
+	if total != 17 {
+		t.Fatalf("expected 17 passwords, not %d", total)
+	}
+	for i := int64(0); i < total; i++ {
+		tokens, err := w.unrank(big.NewInt(i))
+		if err != nil {
+			t.Fatalf("couldn't unrank %d: %v", i, err)
+		}
+		pw := Password{tokens: tokens}.String()
+		if !requireFilter(pw, r.requiredSets) {
+			t.Errorf("%d-th password %q doesn't meet requirements", i, pw)
+		}
+	}
+}
+
+// chainedSets returns n required sets of two letters, each sharing a
+// letter with the next, so that they all form one overlapping group
+func chainedSets(n int) []string {
+	sets := make([]string, n)
+	for i := range sets {
+		sets[i] = lower[i : i+2]
+	}
+	return sets
+}
+
+func TestOverlappingSetLimits(t *testing.T) {
+	r := CharRecipe{Length: 24, Allow: Letters, RequireSets: chainedSets(maxOverlappingSets),
+		Source: newDetSource("chained")}
+	if err := r.Validate(); err != nil {
+		t.Fatalf("%d overlapping sets should be fine: %v", maxOverlappingSets, err)
+	}
+	if e := r.Entropy(); math.IsNaN(float64(e)) || e <= 0 {
+		t.Errorf("expected positive entropy, got %f", e)
+	}
+	c, err := r.Compile()
+	if err != nil {
+		t.Fatalf("couldn't compile: %v", err)
+	}
+	c.trials = 0
+	for i := 0; i < 5; i++ {
+		p, err := c.Generate()
+		if err != nil {
+			t.Fatalf("failed to generate: %v", err)
+		}
+		if !meetsRecipe(r, p.String()) {
+			t.Errorf("%q doesn't meet the recipe", p)
+		}
+	}
+
+	// One more is too many to count
+	r.RequireSets = chainedSets(maxOverlappingSets + 1)
+	if err := r.Validate(); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported from Validate, got %v", err)
+	}
+	if _, err := r.Generate(); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported from Generate, got %v", err)
+	}
+	if _, err := r.Unrank(big.NewInt(0)); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported from Unrank, got %v", err)
+	}
+	if e := r.Entropy(); !math.IsNaN(float64(e)) {
+		t.Errorf("expected NaN entropy, got %f", e)
+	}
+
+	// Counting position by position allows fewer
+	r.RequireSets = chainedSets(maxOverlappingSetsByPosition + 1)
+	r.MaxConsecutive = 2
+	if err := r.Validate(); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported with MaxConsecutive, got %v", err)
+	}
+	r.RequireSets = chainedSets(maxOverlappingSetsByPosition)
+	if err := r.Validate(); err != nil {
+		t.Errorf("%d overlapping sets should be fine with MaxConsecutive: %v", maxOverlappingSetsByPosition, err)
+	}
+}
+
+func TestGenerateExactUnlikely(t *testing.T) {
+	// Rejection sampling would almost never succeed here
+	r := CharRecipe{
+		Length:      6,
+		RequireSets: []string{"a", "b", "c", "d", "e", "f"},
+		AllowChars:  lower + upper + digits,
+		Source:      newDetSource("unlikely"),
+	}
+	c, err := r.Compile()
+	if err != nil {
+		t.Fatalf("couldn't compile: %v", err)
+	}
+	if c.trials != 0 {
+		t.Fatal("this test expects an unacceptable fail rate for rejection sampling")
+	}
+
+	for i := 0; i < 20; i++ {
+		p, err := c.Generate()
+		if err != nil {
+			t.Fatalf("failed to generate: %v", err)
+		}
+		pw := p.String()
+		for _, c := range r.RequireSets {
+			if !strings.Contains(pw, c) {
+				t.Errorf("%q doesn't contain %q", pw, c)
+			}
+		}
+	}
+}
+
+func TestGenerateExactDistribution(t *testing.T) {
+	// Every one of the 8 valid passwords should turn up. With a
+	// deterministic source this test can't fail by chance.
+	r := CharRecipe{Length: 2, RequireSets: []string{"ab", "12"}}
+	c, err := r.Compile()
+	if err != nil {
+		t.Fatalf("couldn't compile: %v", err)
+	}
+	c.trials = 0 // only pick directly, without rejection sampling
+	rs := newDetSource("distribution")
+
+	counts := make(map[string]int)
+	for i := 0; i < 400; i++ {
+		p, err := c.GenerateFrom(rs)
+		if err != nil {
+			t.Fatalf("failed to generate: %v", err)
+		}
+		counts[p.String()]++
+	}
+	if len(counts) != 8 {
+		t.Errorf("expected all 8 passwords, got %v", counts)
+	}
+	for pw, n := range counts {
+		if !requireFilter(pw, c.r.requiredSets) {
+			t.Errorf("%q doesn't meet requirements", pw)
+		}
+		if n < 25 {
+			t.Errorf("%q came up only %d times out of 400", pw, n)
+		}
+	}
+}
+
+func TestGenerateCounts(t *testing.T) {
+	r := CharRecipe{
+		Length:      10,
+		Allow:       Letters,
+		Require:     Digits | Symbols,
+		ClassCounts: map[CTFlag]CountRange{Digits: {Min: 2}, Symbols: {Min: 2, Max: 3}},
+		Source:      newDetSource("counts"),
+	}
+	for i := 0; i < 50; i++ {
+		p, err := r.Generate()
+		if err != nil {
+			t.Fatalf("failed to generate: %v", err)
+		}
+		pw := p.String()
+		digits, symbols := 0, 0
+		for _, c := range pw {
+			if strings.ContainsRune(ctDigits, c) {
+				digits++
+			}
+			if strings.ContainsRune(ctSymbols, c) {
+				symbols++
+			}
+		}
+		if digits < 2 {
+			t.Errorf("%q has fewer than 2 digits", pw)
+		}
+		if symbols < 2 || symbols > 3 {
+			t.Errorf("%q doesn't have 2 or 3 symbols", pw)
+		}
+	}
+
+	// Every password in a small space with bounds should turn up in order
+	r = CharRecipe{
+		Length:      3,
+		RequireSets: []string{"abc", "cde"},
+		SetCounts:   []CountRange{{Max: 1}, {Max: 1}},
+		AllowChars:  "xy",
+	}
+	prev := ""
+	for i := int64(0); i < 60; i++ {
+		p, err := r.Unrank(big.NewInt(i))
+		if err != nil {
+			t.Fatalf("couldn't unrank %d: %v", i, err)
+		}
+		if p.String() <= prev {
+			t.Errorf("%q is not after %q", p, prev)
+		}
+		prev = p.String()
+	}
+	if _, err := r.Unrank(big.NewInt(60)); err == nil {
+		t.Error("there should only be 60 passwords")
+	}
+}
+
+func TestGeneratePositions(t *testing.T) {
+	r := CharRecipe{
+		Length: 12,
+		Allow:  Letters | Digits | Symbols,
+		Positions: []PositionRule{
+			{Position: 0, Allow: Letters},
+			{Position: -1, Exclude: Symbols},
+		},
+		Source: newDetSource("positions"),
+	}
+	for i := 0; i < 50; i++ {
+		p, err := r.Generate()
+		if err != nil {
+			t.Fatalf("failed to generate: %v", err)
+		}
+		pw := p.String()
+		if !strings.ContainsAny(pw[:1], ctUpper+ctLower) {
+			t.Errorf("%q doesn't start with a letter", pw)
+		}
+		if strings.ContainsAny(pw[len(pw)-1:], ctSymbols) {
+			t.Errorf("%q ends with a symbol", pw)
+		}
+		idx, err := r.Rank(p)
+		if err != nil {
+			t.Fatalf("couldn't rank %q: %v", pw, err)
+		}
+		if q, _ := r.Unrank(idx); q.String() != pw {
+			t.Errorf("%q ranked as %v, which unranks to %q", pw, idx, q)
+		}
+	}
+
+	// Rejection sampling can't work when the positions are this restrictive
+	r.Positions = nil
+	for pos := 0; pos < r.Length; pos++ {
+		r.Positions = append(r.Positions, PositionRule{Position: pos, AllowChars: "!"})
+	}
+	p, err := r.Generate()
+	if err != nil {
+		t.Fatalf("failed to generate: %v", err)
+	}
+	if p.String() != "!!!!!!!!!!!!" {
+		t.Errorf("%q should be all '!'", p)
+	}
+}
+
+func TestGenerateRuns(t *testing.T) {
+	// What a fussy bank might ask for
+	r := *NewCharRecipe(20)
+	r.Require = Uppers | Lowers | Digits | Symbols
+	r.MaxConsecutive = 2
+	r.MaxSequence = 2
+	r.Source = newDetSource("runs")
+	r.buildCharacterList()
+	for i := 0; i < 5; i++ {
+		p, err := r.Generate()
+		if err != nil {
+			t.Fatalf("failed to generate: %v", err)
+		}
+		pw := p.String()
+		if !r.accepts(pw) {
+			t.Errorf("%q doesn't meet the recipe", pw)
+		}
+		idx, err := r.Rank(p)
+		if err != nil {
+			t.Fatalf("couldn't rank %q: %v", pw, err)
+		}
+		if q, _ := r.Unrank(idx); q.String() != pw {
+			t.Errorf("%q ranked as %v, which unranks to %q", pw, idx, q)
+		}
+	}
+
+	// Every digit once is too unlikely for rejection sampling
+	r = CharRecipe{Length: 10, Allow: Digits, NoRepeat: true, Source: newDetSource("no repeat")}
+	p, err := r.Generate()
+	if err != nil {
+		t.Fatalf("failed to generate: %v", err)
+	}
+	pw := p.String()
+	for _, d := range ctDigits {
+		if strings.Count(pw, string(d)) != 1 {
+			t.Errorf("%q should have each digit once", pw)
+		}
+	}
+
+	// Small enough to go through all of them
+	r = CharRecipe{Length: 3, AllowChars: "abc1", MaxConsecutive: 1, MaxSequence: 2}
+	chars := r.buildCharacterList()
+	w := newCharWalker(&r, chars)
+	total := w.total().Int64()
+	prev := ""
+	for i := int64(0); i < total; i++ {
+		tokens, err := w.unrank(big.NewInt(i))
+		if err != nil {
+			t.Fatalf("couldn't unrank %d: %v", i, err)
+		}
+		pw := Password{tokens: tokens}.String()
+		if !r.accepts(pw) || pw <= prev {
+			t.Errorf("%d-th password %q is wrong", i, pw)
+		}
+		prev = pw
+	}
+
+	for _, bad := range []string{"aab", "abc"} {
+		p := &Password{}
+		for _, c := range bad {
+			p.tokens = append(p.tokens, Token{string(c), AtomType})
+		}
+		if _, err := r.Rank(p); err == nil {
+			t.Errorf("%q should not have a rank", bad)
+		}
+	}
+}
+
+func TestRankPIN(t *testing.T) {
+	r := CharRecipe{Length: 4, Allow: Digits}
+
+	vecs := []struct {
+		idx int64
+		pin string
+	}{
+		{0, "0000"},
+		{1234, "1234"},
+		{9999, "9999"},
+	}
+	for _, v := range vecs {
+		p, err := r.Unrank(big.NewInt(v.idx))
+		if err != nil {
+			t.Fatalf("couldn't unrank %d: %v", v.idx, err)
+		}
+		if p.String() != v.pin {
+			t.Errorf("Unrank(%d) should be %q, not %q", v.idx, v.pin, p)
+		}
+		idx, err := r.Rank(p)
+		if err != nil {
+			t.Fatalf("couldn't rank %q: %v", p, err)
+		}
+		if idx.Int64() != v.idx {
+			t.Errorf("Rank(%q) should be %d, not %v", p, v.idx, idx)
+		}
+	}
+
+	if _, err := r.Unrank(big.NewInt(10000)); err == nil {
+		t.Error("Unrank(10000) of a 4 digit PIN should fail")
+	}
+	if _, err := r.Unrank(big.NewInt(-1)); err == nil {
+		t.Error("Unrank(-1) should fail")
+	}
+}
+
+func TestRankRoundTrip(t *testing.T) {
+	r := CharRecipe{
+		Length:  12,
+		Allow:   Letters | Digits,
+		Require: Uppers | Digits | Symbols,
+		Exclude: Ambiguous,
+		Source:  newDetSource("rank"),
+	}
+	for i := 0; i < 20; i++ {
+		p, err := r.Generate()
+		if err != nil {
+			t.Fatalf("failed to generate: %v", err)
+		}
+		idx, err := r.Rank(p)
+		if err != nil {
+			t.Fatalf("couldn't rank %q: %v", p, err)
+		}
+		q, err := r.Unrank(idx)
+		if err != nil {
+			t.Fatalf("couldn't unrank %v: %v", idx, err)
+		}
+		if p.String() != q.String() {
+			t.Errorf("%q ranked as %v, which unranks to %q", p, idx, q)
+		}
+	}
+
+	// Something missing a required symbol isn't in the keyspace
+	bad := &Password{}
+	for _, c := range "Abcdefgh2345" {
+		bad.tokens = append(bad.tokens, Token{string(c), AtomType})
+	}
+	if _, err := r.Rank(bad); err == nil {
+		t.Errorf("%q should not have a rank", bad)
+	}
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
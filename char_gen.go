@@ -5,6 +5,7 @@ import (
 	"math"
 	"sort"
 	"strings"
+	"unicode"
 
 	set "github.com/deckarep/golang-set"
 )
@@ -66,6 +67,55 @@ const (
 	All            = Letters | Digits | Symbols
 )
 
+// CountRange bounds how many characters from some class may appear in a password.
+// A Max of 0 means there is no upper limit. (To allow none, Exclude the class.)
+type CountRange struct {
+	Min int // At least this many
+	Max int // No more than this many, unless 0
+}
+
+// ctFlagOrder is the order in which we go through single character types
+var ctFlagOrder = []CTFlag{Uppers, Lowers, Digits, Symbols, Ambiguous}
+
+// charsOfFlags returns all of the characters in the types in f
+func charsOfFlags(f CTFlag) string {
+	chars := ""
+	for _, flag := range ctFlagOrder {
+		if f&flag != 0 {
+			chars += charTypeByFlag[flag]
+		}
+	}
+	return chars
+}
+
+// ctName returns a name for a combination of character types
+func ctName(f CTFlag) string {
+	if name, ok := charTypeNamesByFlag[f]; ok {
+		return name
+	}
+	names := []string{}
+	for _, flag := range ctFlagOrder {
+		if f&flag != 0 {
+			if name, ok := charTypeNamesByFlag[flag]; ok {
+				names = append(names, name)
+			} else {
+				names = append(names, "Ambiguous")
+			}
+		}
+	}
+	return strings.Join(names, "+")
+}
+
+// sortedFlags returns the keys of counts in increasing order
+func sortedFlags(counts map[CTFlag]CountRange) []CTFlag {
+	flags := make([]CTFlag, 0, len(counts))
+	for f := range counts {
+		flags = append(flags, f)
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i] < flags[j] })
+	return flags
+}
+
 // charTypesByFlag
 var charTypeByFlag = map[CTFlag]string{
 	Uppers:    ctUpper,
@@ -87,19 +137,23 @@ var charTypeNamesByFlag = map[CTFlag]string{
 	All:     "All characters",
 }
 
-// Re-generation trials for meeting requirements
+// Re-generation trials for meeting requirements. These are the defaults
+// for recipes that don't set their own MaxTrials and MaxFailRate.
 var (
 	MaxTrials   = 200              // How many times we will try to generate before giving up
 	MaxFailRate = 1.0 / 1000000000 // Maximum acceptable failure rate after MaxTrials
 )
 
-func (r CharRecipe) hasAcceptableFailRate() (bool, float32) {
-	sp := r.SuccessProbability()
-	if sp <= 0.0 {
-		return false, 1.0
+// trialLimits returns the MaxTrials and MaxFailRate that apply to r
+func (r CharRecipe) trialLimits() (int, float64) {
+	trials, failRate := r.MaxTrials, r.MaxFailRate
+	if trials <= 0 {
+		trials = MaxTrials
+	}
+	if failRate <= 0 {
+		failRate = MaxFailRate
 	}
-	failP := math.Pow(1.0-float64(sp), float64(MaxTrials))
-	return failP <= MaxFailRate, float32(failP)
+	return trials, failRate
 }
 
 /*** Character type passwords ***/
@@ -107,67 +161,77 @@ func (r CharRecipe) hasAcceptableFailRate() (bool, float32) {
 // Generate a password using the character generator. The attributes contain
 // all of the details needed for generating the password
 func (r CharRecipe) Generate() (*Password, error) {
+	return r.GenerateFrom(r.Source)
+}
 
-	if r.Length < 1 {
-		return nil, fmt.Errorf("don't ask for passwords of length %d", r.Length)
-	}
-
-	p := &Password{}
-	p.Entropy = r.Entropy()
-
-	chars := r.buildCharacterList()
-	if len(chars) == 0 {
-		return nil, fmt.Errorf("no characters to build pwd from")
-	}
-
-	if acceptable, failP := r.hasAcceptableFailRate(); !acceptable {
-		return nil, fmt.Errorf("Chance of not generated a valid password (%v) is too high", failP)
-	}
-	for i := 0; i < MaxTrials; i++ {
-		tokens := make([]Token, r.Length)
-		for i := 0; i < r.Length; i++ {
-			c := chars[randomUint32n(uint32(len(chars)))]
-			tokens[i] = Token{c, AtomType}
-		}
-		p.tokens = tokens
-
-		ps := p.String() // creating this variable for debugging
-		if requireFilter(ps, r.requiredSets) {
-			return p, nil
-		}
+// GenerateFrom is like Generate, but draws its randomness from rs
+// instead of from r.Source. A nil rs means crypto/rand.
+//
+// Each call works out the recipe from scratch. When generating many
+// passwords from the same recipe, Compile it first.
+func (r CharRecipe) GenerateFrom(rs RandomSource) (*Password, error) {
+	c, err := r.Compile()
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("couldn't generate password complying with requirements after %v attempts", MaxTrials)
+	return c.GenerateFrom(rs)
 }
 
 // buildCharacterList constructs the "alphabet" that is all and only those
 // characters (actually strings of length 1) from which the password will be
 // built. It also ensures that there are no duplicates.
+//
+// Required sets are not made disjoint from each other. A character that is in
+// more than one of them satisfies each of those requirements, and the counting
+// in char_strength.go takes that into account.
 func (r *CharRecipe) buildCharacterList() charList {
 	allowedChars := r.AllowChars
 	excludedChars := r.ExcludeChars
 	r.requiredSets = make(reqSets, 0)
 	for i, s := range r.RequireSets {
 		if len(s) > 0 {
-			r.requiredSets = append(r.requiredSets,
-				*newReqSet(s, fmt.Sprintf("Custom %d", i+1)))
+			req := newReqSet(s, fmt.Sprintf("Custom %d", i+1))
+			if i < len(r.SetCounts) {
+				req.bound(r.SetCounts[i])
+			}
+			r.requiredSets = append(r.requiredSets, *req)
 		}
 	}
-	for f, ct := range charTypeByFlag {
+	for _, f := range ctFlagOrder {
+		ct := charTypeByFlag[f]
 		if r.Allow&f != 0 {
 			allowedChars += ct
 		}
 		if r.Require&f != 0 {
-			ctName, ok := charTypeNamesByFlag[f]
-			if !ok {
-				ctName = "Dunno"
+			req := newReqSet(ct, ctName(f))
+			if cr, ok := r.ClassCounts[f]; ok {
+				req.bound(cr)
 			}
-			r.requiredSets = append(r.requiredSets, *newReqSet(ct, ctName))
+			r.requiredSets = append(r.requiredSets, *req)
 		}
 		if r.Exclude&f != 0 {
 			excludedChars += ct
 		}
 	}
 
+	// Bounds on classes that aren't (just) single Required types.
+	// A minimum makes the class required. A class with only
+	// a maximum is bounded, but otherwise just allowed.
+	var boundedOnly []CTFlag
+	for _, f := range sortedFlags(r.ClassCounts) {
+		cr := r.ClassCounts[f]
+		if _, single := charTypeByFlag[f]; single && r.Require&f != 0 {
+			continue // already taken care of above
+		}
+		if cr.Min > 0 {
+			req := newReqSet(charsOfFlags(f), ctName(f))
+			req.bound(cr)
+			r.requiredSets = append(r.requiredSets, *req)
+		} else if cr.Max > 0 {
+			boundedOnly = append(boundedOnly, f)
+		}
+	}
+
 	// Now we need to clean this all up. First let's make them sets.
 	excludedSet := setFromString(excludedChars)
 	r.allowedSet = setFromString(allowedChars).Difference(excludedSet)
@@ -181,13 +245,176 @@ func (r *CharRecipe) buildCharacterList() charList {
 	}
 
 	alphabetSet := r.allowedSet.Union(r.requiredSets.union().s)
-	return strings.Split(stringFromSet(alphabetSet), "")
+
+	// Classes that are only bounded don't add anything to the alphabet,
+	// but they still need to be counted separately from what is simply allowed
+	for _, f := range boundedOnly {
+		req := newReqSet(charsOfFlags(f), ctName(f))
+		req.min = 0
+		req.bound(r.ClassCounts[f])
+		req.s = req.s.Intersect(alphabetSet)
+		r.allowedSet = r.allowedSet.Difference(req.s)
+		r.requiredSets = append(r.requiredSets, *req)
+	}
+
+	r.buildPositionSets(alphabetSet)
+
+	// Sorting makes the alphabet, and so generation from a given
+	// RandomSource, reproducible
+	abc := charList(strings.Split(stringFromSet(alphabetSet), ""))
+	sort.Strings(abc)
+	return abc
+}
+
+// buildPositionSets works out, from r.Positions, which characters
+// of the alphabet may appear at each position. It leaves r.positionSets
+// nil if there are no rules for positions within the password.
+func (r *CharRecipe) buildPositionSets(alphabet set.Set) {
+	r.positionSets = nil
+	for _, pr := range r.Positions {
+		pos := pr.Position
+		if pos < 0 {
+			pos += r.Length
+		}
+		if pos < 0 || pos >= r.Length {
+			continue
+		}
+		if r.positionSets == nil {
+			r.positionSets = make([]set.Set, r.Length)
+		}
+
+		allowed := alphabet.Clone()
+		if pr.Allow != None || pr.AllowChars != "" {
+			allowed = allowed.Intersect(setFromString(charsOfFlags(pr.Allow) + pr.AllowChars))
+		}
+		allowed = allowed.Difference(setFromString(charsOfFlags(pr.Exclude) + pr.ExcludeChars))
+		if prev := r.positionSets[pos]; prev != nil {
+			allowed = allowed.Intersect(prev)
+		}
+		r.positionSets[pos] = allowed
+	}
+}
+
+// accepts checks a candidate password, made from the alphabet, against
+// the requirements, position rules, and limits on runs of the recipe.
+// buildCharacterList must already have been run on r.
+func (r CharRecipe) accepts(pwd string) bool {
+	chars := strings.Split(pwd, "")
+	if r.positionSets != nil {
+		for pos, c := range chars {
+			if pos < len(r.positionSets) && r.positionSets[pos] != nil && !r.positionSets[pos].Contains(c) {
+				return false
+			}
+		}
+	}
+	return r.acceptsRuns(chars) && requireFilter(pwd, r.requiredSets)
+}
+
+// limitsRuns is true if the recipe restricts repeated characters or sequences
+func (r CharRecipe) limitsRuns() bool {
+	return r.NoRepeat || r.MaxConsecutive > 0 || r.MaxSequence > 0
+}
+
+// runsError reports combinations of limits on runs that we can't count.
+// Knowing whether a sequence may continue without repeating a character
+// would mean keeping track of exactly which characters have been used.
+func (r CharRecipe) runsError() error {
+	if r.NoRepeat && r.MaxSequence > 0 {
+		return fmt.Errorf("NoRepeat can't be combined with MaxSequence")
+	}
+	return nil
+}
+
+// acceptsRuns checks chars against NoRepeat, MaxConsecutive, and MaxSequence
+func (r CharRecipe) acceptsRuns(chars []string) bool {
+	seen := make(map[string]bool)
+	run, seq, dir := 0, 0, 0
+	for i, c := range chars {
+		if r.NoRepeat && seen[c] {
+			return false
+		}
+		seen[c] = true
+
+		if i > 0 && c == chars[i-1] {
+			run++
+		} else {
+			run = 1
+		}
+		d := 0
+		if i > 0 {
+			d = seqStep(chars[i-1], c)
+		}
+		switch {
+		case d == 0:
+			seq, dir = 1, 0
+		case d == dir:
+			seq++
+		default:
+			seq, dir = 2, d
+		}
+		if r.MaxConsecutive > 0 && run > r.MaxConsecutive {
+			return false
+		}
+		if r.MaxSequence > 0 && seq > r.MaxSequence {
+			return false
+		}
+	}
+	return true
 }
 
-// Entropy returns the entropy of a character password given the generator attributes
+// inSequences tells whether c can be part of an ascending or descending
+// run. Only letters and digits can.
+func inSequences(c string) bool {
+	rs := []rune(c)
+	return len(rs) == 1 && (unicode.IsLetter(rs[0]) || unicode.IsDigit(rs[0]))
+}
+
+// seqStep is 1 if b follows a in an ascending run, such as "ab" or "12",
+// -1 if it follows in a descending run, and 0 otherwise
+func seqStep(a, b string) int {
+	if !inSequences(a) || !inSequences(b) {
+		return 0
+	}
+	switch []rune(b)[0] - []rune(a)[0] {
+	case 1:
+		return 1
+	case -1:
+		return -1
+	}
+	return 0
+}
+
+// seqNeighbor is the character d (1 or -1) after c, if the two could
+// be part of a run, or "" if they couldn't
+func seqNeighbor(c string, d rune) string {
+	if !inSequences(c) {
+		return ""
+	}
+	next := string([]rune(c)[0] + d)
+	if !inSequences(next) {
+		return ""
+	}
+	return next
+}
+
+// needsCounting is true when there is more to the recipe than picking each
+// character from the alphabet. buildCharacterList must already have been run on r.
+func (r CharRecipe) needsCounting() bool {
+	return len(r.requiredSets) != 0 || r.positionSets != nil || r.limitsRuns()
+}
+
+// Entropy returns the entropy of a character password given the generator attributes.
+// It is NaN for recipes that combine NoRepeat with MaxSequence, or that have
+// too many required sets sharing characters to count.
 func (r CharRecipe) Entropy() float32 {
+	if r.runsError() != nil {
+		return float32(math.NaN())
+	}
 	cl := r.buildCharacterList()
-	if r.requiredSets.size() != 0 {
+	if r.overlapError() != nil {
+		return float32(math.NaN())
+	}
+	if r.needsCounting() {
 		return r.entropyWithRequired()
 	}
 	size := len(cl)
@@ -202,6 +429,22 @@ func (r CharRecipe) Entropy() float32 {
 // Exclusion overrides Require and Allow.
 //
 // Require - At least one character from each of these sets must be present in the generated password.
+// Custom RequireSets may overlap, in which case a single character can meet more than one requirement.
+// Counting the passwords goes through the combinations of sets that are linked by shared characters,
+// so no more than 16 sets may be linked that way, or 8 when there are also ClassCounts, SetCounts,
+// Positions, or limits on runs. Recipes with more can't be used, and fail with ErrUnsupported.
+//
+// ClassCounts and SetCounts - Put bounds on the number of characters from a class that may appear.
+// ClassCounts is keyed by character types (or combinations of them, such as Letters).
+// A minimum makes the type required. SetCounts go with RequireSets, by position.
+//
+// Positions - Restrict which characters may appear at particular positions, such as
+// "must start with a letter". Positions only narrow down the alphabet, they never add to it.
+//
+// NoRepeat, MaxConsecutive, and MaxSequence - Limit repeated characters and runs,
+// as some sites reject passwords like "aaa" or "abc". A run is of letters or
+// digits next to each other in Unicode, going up or down, so "321" is a run of
+// three but "a-b" isn't. NoRepeat can't be combined with MaxSequence.
 type CharRecipe struct {
 	Length int // Length of generated password in characters
 
@@ -215,9 +458,46 @@ type CharRecipe struct {
 	RequireSets  []string // At least one character from each string must appear
 	ExcludeChars string   // Specific characters that must not appear
 
+	// Bounds on the number of characters from types and from RequireSets
+	ClassCounts map[CTFlag]CountRange // Bounds for character types
+	SetCounts   []CountRange          // Bounds for RequireSets, in the same order
+
+	Positions []PositionRule // Restrictions on characters at particular positions
+
+	// Limits on repeated characters and runs
+	NoRepeat       bool // No character may appear more than once
+	MaxConsecutive int  // If positive, no character may appear more than this many times in a row
+	MaxSequence    int  // If positive, no ascending or descending run may be longer than this
+
+	// Rejection sampling is tried first, when it fails less than MaxFailRate of
+	// the time within MaxTrials trials. If not positive, the package
+	// variables of the same names are used. Neither changes which passwords
+	// can be generated, only how fast it is.
+	MaxTrials   int
+	MaxFailRate float64
+
+	Source RandomSource // Where random bytes come from. If nil, crypto/rand is used
+
 	// Following sets are computed
-	allowedSet   set.Set // Allowed, but not required
-	requiredSets reqSets // List of sets of required characters
+	allowedSet   set.Set   // Allowed, but not required
+	requiredSets reqSets   // List of sets of required characters
+	positionSets []set.Set // What may appear at each position (nil for anything), or nil if no rules
+}
+
+// PositionRule restricts the characters that may appear at one position of a
+// password. If several rules apply to the same position, all must be met.
+// Rules for positions outside of the password have no effect.
+type PositionRule struct {
+	Position int // 0 is the first character, 1 the second, ... and -1 is the last, -2 next to last, ...
+
+	// Characters that may appear at the position. If both are empty, anything from
+	// the recipe's alphabet may.
+	Allow      CTFlag
+	AllowChars string
+
+	// Characters that must not appear at the position
+	Exclude      CTFlag
+	ExcludeChars string
 }
 
 // NewCharRecipe creates CharRecipe with reasonable defaults and Length length
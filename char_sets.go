@@ -12,26 +12,53 @@ import (
 type reqSet struct {
 	Name string  // To report which set wasn't matched on failure
 	s    set.Set // this is the actual set.
+	min  int     // at least this many characters from s must appear
+	max  int     // and no more than this many, unless 0
 }
 
 type reqSets []reqSet
 
 // requireFilter checks whether a candidate password has a character
-// from each required character set
+// from each required character set, and is within the bounds of each set
 func requireFilter(pwd string, require reqSets) bool {
 	if require == nil || len(require) == 0 {
 		return true
 	}
+	chars := strings.Split(pwd, "")
 	for _, rset := range require {
-		// ContainsAny does not treat empty strings like empty sets
-		rs := stringFromSet(rset.s) // Separate var for debugging
-		if rset.size() > 0 && !strings.ContainsAny(pwd, rs) {
+		if rset.size() == 0 {
+			continue
+		}
+		count := 0
+		for _, c := range chars {
+			if rset.s.Contains(c) {
+				count++
+			}
+		}
+		if !rset.allows(count) {
 			return false
 		}
 	}
 	return true
 }
 
+// allows tells whether count characters from the set is within its bounds
+func (r reqSet) allows(count int) bool {
+	if count < r.min {
+		return false
+	}
+	return r.max == 0 || count <= r.max
+}
+
+// bound sets the bounds of a set from cr. It never lowers the minimum,
+// so required sets still need at least one character whatever cr says.
+func (r *reqSet) bound(cr CountRange) {
+	if cr.Min > r.min {
+		r.min = cr.Min
+	}
+	r.max = cr.Max
+}
+
 func (r CharRecipe) fullAlphabet() (charList, error) {
 	if r.allowedSet == nil {
 		return nil, fmt.Errorf("allowedSet is nil")
@@ -67,6 +94,7 @@ func newReqSet(s, name string) *reqSet {
 	r := &reqSet{
 		Name: name,
 		s:    setFromString(s),
+		min:  1,
 	}
 	return r
 }
@@ -103,6 +131,24 @@ func (rs reqSets) union() reqSet {
 	return u
 }
 
+// sets returns the characters of each of the required sets
+func (rs reqSets) sets() []set.Set {
+	out := make([]set.Set, len(rs))
+	for i, r := range rs {
+		out[i] = r.s
+	}
+	return out
+}
+
+// names lists the names of the required sets, for error reporting
+func (rs reqSets) names() []string {
+	out := make([]string, len(rs))
+	for i, r := range rs {
+		out[i] = r.Name
+	}
+	return out
+}
+
 func (rs reqSets) size() int {
 	if len(rs) == 0 {
 		return 0
@@ -110,6 +156,29 @@ func (rs reqSets) size() int {
 	return rs.union().s.Cardinality()
 }
 
+// minLength is the fewest characters that could meet all of the minimums.
+// When sets overlap one character can count for several of them,
+// so then the best we can say is that the largest minimum is needed.
+func (rs reqSets) minLength() int {
+	sum, largest := 0, 0
+	disjoint := true
+	for i, r := range rs {
+		sum += r.min
+		if r.min > largest {
+			largest = r.min
+		}
+		for _, other := range rs[i+1:] {
+			if r.s.Intersect(other.s).Cardinality() > 0 {
+				disjoint = false
+			}
+		}
+	}
+	if disjoint {
+		return sum
+	}
+	return largest
+}
+
 func (r reqSet) size() int {
 	if r.s == nil {
 		return 0
@@ -2,9 +2,8 @@ package spg
 
 import (
 	"log"
-	"math"
 	"math/big"
-	"strings"
+	"math/bits"
 
 	set "github.com/deckarep/golang-set"
 )
@@ -13,105 +12,276 @@ import (
 // passwords. The trick is for when a character is _required_ from a particular set
 
 func (r CharRecipe) entropyWithRequired() float32 {
-	intValue := r.n()
-	floatValue := big.NewFloat(0).SetInt(intValue)
-
-	// big.Float doesn't have a Log function, so we need to use a float64.
-	// See https://github.com/golang/go/issues/14102
-	// Avoid float64 overflows by splitting up the mantissa and exponent.
-	mantissa := big.NewFloat(0)
-	expo := floatValue.MantExp(mantissa)
-	float64Mantissa, _ := mantissa.Float64()
-
-	// Fun log math:
-	//   log2(intValue) =
-	//   log2(intValue / 2 ** N * 2 ** N) =
-	//   log2(intValue / 2 ** N) + log2(2 ** N) =
-	//   log2(intValue / 2 ** N) + N
-	return float32(math.Log2(float64Mantissa) + float64(expo))
+	return log2Big(r.n())
 }
 
+// n is the number of passwords the recipe, r, can produce.
+// buildCharacterList must already have been run on r.
 func (r CharRecipe) n() *big.Int {
-	allowed := set.NewSet()
-	allowed.Add(r.allowedSet)
-	required := set.NewSet()
-	for _, req := range r.requiredSets {
-		required.Add(req.s)
+	if r.allowedSet == nil || r.Length < 0 || r.runsError() != nil || r.overlapError() != nil {
+		return new(big.Int)
 	}
-
-	return n(allowed, required, r.Length)
+	abc, _ := r.fullAlphabet()
+	return newCharWalker(&r, abc).total()
 }
 
-// n is the number of possible passwords that can be generated.
+// The number of possible passwords of length length, drawn from an
+// alphabet of size characters, that contain at least one character from each
+// of the required sets is
+//
+//    countAvoiding(size, avoidCoefs(size, required), length)
+//
+// The required sets must be subsets of the alphabet, but they may overlap.
+// A single character can satisfy more than one of them, just as in requireFilter.
+// It is split in two as the coefficients don't depend on the length, so they
+// can be kept for counting different lengths with the same sets.
+//
 // Unfortunately, we can't take the log until the very end, so we will
 // be dealing with some very large numbers.
-func n(allowed set.Set, required set.Set, length int) *big.Int {
-	// totalCount is the total number of permutations possible when a
-	// password of length n is generated from the set R, which is the
-	// union of all sets in the password recipe.
-	R := unionAll(allowed.Union(required))
-	totalCount := &big.Int{}
-	totalCount.Exp(toBigInt(R.Cardinality()), toBigInt(length), nil) // #nosec G105
-
-	// Each of these sets of sets represents a password recipe that we
-	// will reject and thus must subtract from our total count.
-	// We want to reject all subsets of the set of required sets except
-	// the set of required sets itself.
-	// For example, if L and D are required, rejectedSubsets
-	// will contain {L} and {D} and will not contain {L, D}.
-	// Optional sets are not part of this at all because they will
-	// simply be tacked on at the end.
-	powerSet := required.PowerSet()
-	rejectedSubsets := set.NewSet()
-	for el := range powerSet.Iter() {
-		elSet, ok := el.(set.Set)
-		if ok && !required.Equal(elSet) {
-			rejectedSubsets.Add(elSet)
+
+// avoidCoefs returns the coefficients that countAvoiding needs for the required sets
+func avoidCoefs(size int, required []set.Set) []*big.Int {
+	// By inclusion-exclusion, the count is the sum over every subset S of the
+	// required sets of
+	//    (-1)^|S| * (size - |union(S)|)^length
+	// That is, we count passwords that avoid every set in S, alternately
+	// adding and subtracting to correct for overlaps.
+	//
+	// Summing over all 2^k subsets gets expensive quickly, but the terms only
+	// depend on |union(S)|. So we instead build up the signed number of subsets
+	// for each possible |union(S)|. Groups of required sets that don't overlap
+	// with any others contribute independently. So we only need to go through
+	// the subsets of each group, and then combine groups one at a time.
+	// When no required sets overlap that takes k * size steps.
+	coef := make([]*big.Int, size+1) // coef[t] is sum of (-1)^|S| for |union(S)| == t
+	for t := range coef {
+		coef[t] = new(big.Int)
+	}
+	coef[0].SetInt64(1)
+	maxT := 0
+	for _, group := range overlapGroups(required) {
+		terms := groupTerms(group)
+		next := make([]*big.Int, size+1)
+		for t := range next {
+			next[t] = new(big.Int)
+		}
+		for t := 0; t <= maxT; t++ {
+			if coef[t].Sign() == 0 {
+				continue
+			}
+			for u, c := range terms {
+				if c != 0 && t+u <= size {
+					next[t+u].Add(next[t+u], new(big.Int).Mul(coef[t], big.NewInt(c)))
+				}
+			}
+		}
+		coef = next
+		maxT += len(terms) - 1
+		if maxT > size {
+			maxT = size
 		}
 	}
+	return coef
+}
 
-	// When requiredSets is {{}} (it is a set containing only the empty set),
-	// powerSet(requiredSets) will also be {{}};
-	// thus, rejectedSubsets will be empty, the reducing
-	// function below will not run, and rejectedCount will be 0,
-	// terminating the recursion.
+// countAvoiding sums the terms of the inclusion-exclusion for passwords of
+// length length, given the coefficients from avoidCoefs
+func countAvoiding(size int, coef []*big.Int, length int) *big.Int {
+	count := new(big.Int)
+	if length < 0 {
+		return count
+	}
+	term := new(big.Int)
+	for t, c := range coef {
+		if c.Sign() == 0 {
+			continue
+		}
+		term.Exp(toBigInt(size-t), toBigInt(length), nil) // #nosec G105
+		term.Mul(term, c)
+		count.Add(count, term)
+	}
+	return count
+}
 
-	rejectedCount := sumAll(
-		rejectedSubsets,
-		func(subset set.Set) *big.Int {
-			return n(allowed, subset, length)
-		},
-	)
+// overlapGroups splits sets into groups, such that no set in one group
+// shares a character with a set in another group
+func overlapGroups(sets []set.Set) [][]set.Set {
+	// A simple union-find on set indices
+	parent := make([]int, len(sets))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range sets {
+		for j := i + 1; j < len(sets); j++ {
+			if sets[i].Intersect(sets[j]).Cardinality() > 0 {
+				parent[find(i)] = find(j)
+			}
+		}
+	}
 
-	return totalCount.Sub(totalCount, rejectedCount)
+	var groups [][]set.Set
+	groupOf := make(map[int]int)
+	for i, s := range sets {
+		root := find(i)
+		g, ok := groupOf[root]
+		if !ok {
+			g = len(groups)
+			groupOf[root] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], s)
+	}
+	return groups
 }
 
-func toBigInt(i int) *big.Int {
-	return big.NewInt(int64(i))
+// groupTerms returns, for each t, the sum of (-1)^|S| over subsets S of group
+// with |union(S)| == t. A group with a single set, s, has terms
+// {1, 0, ..., 0, -1} where the -1 is at |s|.
+func groupTerms(group []set.Set) []int64 {
+	if len(group) == 1 {
+		terms := make([]int64, group[0].Cardinality()+1)
+		terms[0]++
+		terms[len(terms)-1]--
+		return terms
+	}
+
+	// Overlapping sets force us to look at each subset of the group.
+	// There can be a lot of those, so each set becomes a bit mask over the
+	// characters of the group, to make unions cheap.
+	bit := make(map[interface{}]uint)
+	for _, s := range group {
+		for _, c := range s.ToSlice() {
+			if _, ok := bit[c]; !ok {
+				bit[c] = uint(len(bit))
+			}
+		}
+	}
+	words := (len(bit) + 63) / 64
+	masks := make([][]uint64, len(group))
+	for i, s := range group {
+		masks[i] = make([]uint64, words)
+		for _, c := range s.ToSlice() {
+			masks[i][bit[c]/64] |= 1 << (bit[c] % 64)
+		}
+	}
+
+	// unions[i] is the union of the sets chosen from the first i of the group
+	terms := make([]int64, len(bit)+1)
+	unions := make([][]uint64, len(group)+1)
+	for i := range unions {
+		unions[i] = make([]uint64, words)
+	}
+	var walk func(i int, sign int64)
+	walk = func(i int, sign int64) {
+		if i == len(group) {
+			t := 0
+			for _, w := range unions[i] {
+				t += bits.OnesCount64(w)
+			}
+			terms[t] += sign
+			return
+		}
+		copy(unions[i+1], unions[i])
+		walk(i+1, sign)
+		for j, m := range masks[i] {
+			unions[i+1][j] = unions[i][j] | m
+		}
+		walk(i+1, -sign)
+	}
+	walk(0, 1)
+	return terms
 }
 
-// Mimic the mathematical sum operator
-func sumAll(s set.Set, transform func(s set.Set) *big.Int) *big.Int {
-	sum := &big.Int{}
-	for el := range s.Iter() {
-		elSet, ok := el.(set.Set)
-		if ok {
-			sum.Add(sum, transform(elSet))
+// classBound is a class of characters along with bounds on how many
+// characters from the class may appear
+type classBound struct {
+	size int
+	lo   int // at least this many
+	hi   int // at most this many, or no limit if negative
+}
+
+// boundedCount is the number of passwords of length length drawn from free
+// unconstrained characters along with characters from the classes, with
+// the number of characters from each class within its bounds.
+// The classes must be disjoint from each other and from the free characters.
+func boundedCount(free int, classes []classBound, length int) *big.Int {
+	if length < 0 {
+		return new(big.Int)
+	}
+	binom := pascal(length)
+
+	// ways[t] is the number of strings of length t made only from the
+	// classes considered so far, each within its bounds. Adding c characters
+	// from a new class to such a string means choosing which c of the t+c
+	// positions they go in and which character goes in each.
+	ways := bigZeros(length + 1)
+	ways[0].SetInt64(1)
+	term := new(big.Int)
+	for _, cl := range classes {
+		next := bigZeros(length + 1)
+		hi := cl.hi
+		if hi < 0 || hi > length {
+			hi = length
+		}
+		for t := 0; t <= length; t++ {
+			if ways[t].Sign() == 0 {
+				continue
+			}
+			for c := cl.lo; c <= hi && t+c <= length; c++ {
+				term.Exp(toBigInt(cl.size), toBigInt(c), nil)
+				term.Mul(term, binom[t+c][c])
+				term.Mul(term, ways[t])
+				next[t+c].Add(next[t+c], term)
+			}
+		}
+		ways = next
+	}
+
+	// and everything else is filled in from the free characters
+	count := new(big.Int)
+	for t := 0; t <= length; t++ {
+		if ways[t].Sign() == 0 {
+			continue
 		}
+		term.Exp(toBigInt(free), toBigInt(length-t), nil)
+		term.Mul(term, binom[length][t])
+		term.Mul(term, ways[t])
+		count.Add(count, term)
 	}
-	return sum
+	return count
 }
 
-// Mimic the mathematical big union (bigcup) operator
-func unionAll(elements set.Set) set.Set {
-	combined := set.NewSet()
-	for el := range elements.Iter() {
-		elSet, ok := el.(set.Set)
-		if ok {
-			combined = combined.Union(elSet)
+// pascal returns binomial coefficients, with [n][k] being n choose k, for n up to size
+func pascal(size int) [][]*big.Int {
+	rows := make([][]*big.Int, size+1)
+	for n := range rows {
+		rows[n] = make([]*big.Int, n+1)
+		rows[n][0] = big.NewInt(1)
+		rows[n][n] = big.NewInt(1)
+		for k := 1; k < n; k++ {
+			rows[n][k] = new(big.Int).Add(rows[n-1][k-1], rows[n-1][k])
 		}
 	}
-	return combined
+	return rows
+}
+
+func bigZeros(size int) []*big.Int {
+	out := make([]*big.Int, size)
+	for i := range out {
+		out[i] = new(big.Int)
+	}
+	return out
+}
+
+func toBigInt(i int) *big.Int {
+	return big.NewInt(int64(i))
 }
 
 // SuccessProbability returns the chances of meeting all of the Require-ments
@@ -119,30 +289,17 @@ func unionAll(elements set.Set) set.Set {
 func (r CharRecipe) SuccessProbability() float32 {
 
 	/* The probability of generating a password that meets the Requirements
-	   on a single trial is the ratio of r.n()/rWithAllRequiredChangedToAllowed.n()
-
-	   But to avoid having to read the Go docs about big Quotients, replace that
-	   division with a substraction of their logarithms. Conveniently, we have
-	   those as the Entropy. Then we just raise 2 to that difference.
+	   on a single trial of picking each character from the whole alphabet
+	   is the ratio of r.n() to the number of all strings of length r.Length
+	   over the alphabet.
 	*/
 
-	newAllowChars := r.AllowChars + strings.Join(r.RequireSets, "")
-	newRequireSets := []string{}
-	rCopy := r
-	rCopy.AllowChars = newAllowChars
-	rCopy.RequireSets = newRequireSets
-	rCopy.Allow = r.Allow | r.Require
-	rCopy.Require = None
-
-	eDiff := r.Entropy() - rCopy.Entropy()
-	if eDiff > 0.0 {
-		// This should never happen, but I don't want to
-		// log.Fatal in a library
-		log.Println("successProbability: eDiff is positive. Setting to 0")
-		eDiff = 0.0
+	abc := r.buildCharacterList()
+	all := new(big.Int).Exp(toBigInt(len(abc)), toBigInt(r.Length), nil)
+	if all.Sign() == 0 || r.Length < 0 {
+		return 0.0
 	}
-
-	p := float32(math.Exp2(float64(eDiff)))
+	p, _ := new(big.Rat).SetFrac(r.n(), all).Float32()
 	if p > 1.0 {
 		// Can't happen, but still
 		log.Println("successProbability: p greater than 1. Setting to 1")
@@ -2,7 +2,10 @@ package spg
 
 import (
 	"math"
+	"math/big"
+	"strings"
 	"testing"
+	"time"
 )
 
 // expectation is data for a single entropy test
@@ -17,6 +20,9 @@ type expectation struct {
 	RequireSets  []string
 	ExcludeChars string
 
+	ClassCounts map[CTFlag]CountRange
+	SetCounts   []CountRange
+
 	N int64
 }
 
@@ -56,6 +62,31 @@ var expectations = []expectation{
 	{Allow: Uppers, ExcludeChars: "ABC", Length: 1, N: 23},
 	{Require: Uppers, ExcludeChars: "ABC", Length: 1, N: 23},
 	{RequireSets: []string{"a", "AB"}, AllowChars: "12", ExcludeChars: "B2", Length: 3, N: 12},
+
+	// Overlapping required sets. A single "c" satisfies both.
+	{RequireSets: []string{"abc", "cde"}, Length: 1, N: 1},
+	{RequireSets: []string{"abc", "cde"}, Length: 2, N: 17},
+	{RequireSets: []string{"abc", "abc"}, Length: 2, N: 9},
+	{RequireSets: []string{"abc", "a"}, Length: 2, N: 5},
+	{RequireSets: []string{"ab", "bc", "ca"}, Length: 2, N: 6},
+	{Require: Lowers, RequireSets: []string{"aeiou"}, Length: 1, N: 5},
+
+	// Bounds on counts.
+	// sum_{c=2}^{3} C(3,c) 10^c 26^(3-c) = 7800 + 1000
+	{Allow: Lowers | Digits, ClassCounts: map[CTFlag]CountRange{Digits: {Min: 2}}, Length: 3, N: 8800},
+	{Allow: Lowers, Require: Digits, ClassCounts: map[CTFlag]CountRange{Digits: {Min: 2, Max: 2}}, Length: 3, N: 7800},
+	// 26^2 + 2*6*26
+	{Allow: Lowers | Symbols, ClassCounts: map[CTFlag]CountRange{Symbols: {Max: 1}}, Length: 2, N: 988},
+	// A bound on a type that isn't allowed changes nothing
+	{Allow: Lowers, ClassCounts: map[CTFlag]CountRange{Symbols: {Max: 1}}, Length: 2, N: 676},
+	{RequireSets: []string{"ab"}, SetCounts: []CountRange{{Min: 2}}, AllowChars: "x", Length: 2, N: 4},
+	{RequireSets: []string{"ab"}, SetCounts: []CountRange{{Min: 3}}, AllowChars: "x", Length: 2, N: 0},
+	{RequireSets: []string{"ab"}, SetCounts: []CountRange{{Min: 2, Max: 1}}, AllowChars: "x", Length: 3, N: 0},
+	// Overlapping sets with bounds: exactly one from each of "abc" and "cde"
+	// is either "c" with the rest from "xy", or one each of "ab" and "de".
+	// For length 2 that is 2*2 + 2*2*2, and for length 3 it is 3*2*2 + 6*2*2*2
+	{RequireSets: []string{"abc", "cde"}, SetCounts: []CountRange{{Max: 1}, {Max: 1}}, AllowChars: "xy", Length: 2, N: 12},
+	{RequireSets: []string{"abc", "cde"}, SetCounts: []CountRange{{Max: 1}, {Max: 1}}, AllowChars: "xy", Length: 3, N: 60},
 }
 
 func TestN(t *testing.T) {
@@ -68,6 +99,8 @@ func TestN(t *testing.T) {
 			AllowChars:   exp.AllowChars,
 			RequireSets:  exp.RequireSets,
 			ExcludeChars: exp.ExcludeChars,
+			ClassCounts:  exp.ClassCounts,
+			SetCounts:    exp.SetCounts,
 		}
 		recipe.buildCharacterList()
 		intResult := recipe.n().Int64()
@@ -78,6 +111,180 @@ func TestN(t *testing.T) {
 	}
 }
 
+func TestNManyRequired(t *testing.T) {
+	// With k required sets of one character each, and N characters in all,
+	// the count is sum_{j=0}^{k} (-1)^j C(k, j) (N-j)^length
+	sets := strings.Split("ABCDEFGHIJKLMNOP", "")
+	recipe := &CharRecipe{
+		Length:      24,
+		AllowChars:  lower + digits,
+		RequireSets: sets,
+	}
+
+	start := time.Now()
+	recipe.buildCharacterList()
+	got := recipe.n()
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("counting with %d required sets took %v", len(sets), d)
+	}
+
+	k := int64(len(sets))
+	total := int64(len(lower+digits)) + k
+	want := new(big.Int)
+	for j := int64(0); j <= k; j++ {
+		term := new(big.Int).Exp(big.NewInt(total-j), big.NewInt(int64(recipe.Length)), nil)
+		term.Mul(term, new(big.Int).Binomial(k, j))
+		if j%2 == 1 {
+			term.Neg(term)
+		}
+		want.Add(want, term)
+	}
+	if got.Cmp(want) != 0 {
+		t.Errorf("n() should be %v, was %v", want, got)
+	}
+}
+
+// bruteForceN counts, one by one, the passwords over abc that
+// the recipe accepts
+func bruteForceN(recipe *CharRecipe, abc []string) int64 {
+	length := recipe.Length
+	count := int64(0)
+	idx := make([]int, length)
+	for {
+		pw := ""
+		for _, i := range idx {
+			pw += abc[i]
+		}
+		if recipe.accepts(pw) {
+			count++
+		}
+		// Next password, like an odometer
+		pos := length - 1
+		for ; pos >= 0; pos-- {
+			idx[pos]++
+			if idx[pos] < len(abc) {
+				break
+			}
+			idx[pos] = 0
+		}
+		if pos < 0 {
+			return count
+		}
+	}
+}
+
+func TestNOverlapBruteForce(t *testing.T) {
+	setLists := [][]string{
+		{"abc", "cde"},
+		{"abc", "cde", "efa"},
+		{"ab", "bc", "cd", "xy"},
+		{"abcd", "b", "d", "x", "xyz"},
+		{"abcdef", "ace", "bdf", "af"},
+	}
+	for _, sets := range setLists {
+		for length := 1; length <= 5; length++ {
+			recipe := &CharRecipe{Length: length, AllowChars: "m", RequireSets: sets}
+			abc := recipe.buildCharacterList()
+			want := bruteForceN(recipe, abc)
+			if got := recipe.n().Int64(); got != want {
+				t.Errorf("%v, length %d: n() is %d, brute force gives %d", sets, length, got, want)
+			}
+		}
+	}
+}
+
+func TestNBoundsBruteForce(t *testing.T) {
+	recipes := []CharRecipe{
+		{Length: 4, AllowChars: "ab", Allow: Digits, ClassCounts: map[CTFlag]CountRange{Digits: {Min: 1, Max: 2}}},
+		{Length: 4, AllowChars: "ab", Require: Digits, ClassCounts: map[CTFlag]CountRange{Digits: {Max: 2}}},
+		{Length: 4, AllowChars: "abc", RequireSets: []string{"ab", "12"}, SetCounts: []CountRange{{Min: 2}, {Max: 1}}},
+		{Length: 4, AllowChars: "xy", RequireSets: []string{"abc", "cde", "ax"}, SetCounts: []CountRange{{Min: 2}, {}, {Max: 2}}},
+		{Length: 4, Allow: Digits, AllowChars: "ab", RequireSets: []string{"a1"}, ClassCounts: map[CTFlag]CountRange{Digits: {Max: 3}}},
+	}
+	for i, recipe := range recipes {
+		abc := recipe.buildCharacterList()
+		want := bruteForceN(&recipe, abc)
+		if got := recipe.n().Int64(); got != want {
+			t.Errorf("%d: n() is %d, brute force gives %d", i, got, want)
+		}
+	}
+}
+
+func TestNPositions(t *testing.T) {
+	// Must start with a letter and must not end with a symbol:
+	// 52 * 68^6 * 62 with 68 being letters, digits and symbols
+	recipe := &CharRecipe{
+		Length: 8,
+		Allow:  Letters | Digits | Symbols,
+		Positions: []PositionRule{
+			{Position: 0, Allow: Letters},
+			{Position: -1, Exclude: Symbols},
+		},
+	}
+	recipe.buildCharacterList()
+	want := new(big.Int).Exp(big.NewInt(68), big.NewInt(6), nil)
+	want.Mul(want, big.NewInt(52*62))
+	if got := recipe.n(); got.Cmp(want) != 0 {
+		t.Errorf("n() should be %v, was %v", want, got)
+	}
+
+	recipes := []CharRecipe{
+		{Length: 4, AllowChars: "ab", Allow: Digits, Positions: []PositionRule{{Position: 0, AllowChars: "ab"}}},
+		{Length: 4, AllowChars: "abc", Require: Digits, Positions: []PositionRule{{Position: 0, Exclude: Digits}, {Position: -1, Exclude: Digits}}},
+		{Length: 4, AllowChars: "abc", RequireSets: []string{"12", "2x"}, Positions: []PositionRule{{Position: 1, AllowChars: "2ax"}, {Position: 1, ExcludeChars: "a"}}},
+		{Length: 4, AllowChars: "ab", Allow: Digits, ClassCounts: map[CTFlag]CountRange{Digits: {Min: 2, Max: 3}}, Positions: []PositionRule{{Position: -2, Allow: Digits}}},
+		// Out of range positions have no effect, and positions never add to the alphabet
+		{Length: 3, AllowChars: "abc", Positions: []PositionRule{{Position: 3, AllowChars: "a"}, {Position: -4, AllowChars: "a"}, {Position: 1, AllowChars: "aZ"}}},
+		// Impossible: nothing allowed at the first position
+		{Length: 3, AllowChars: "abc", Positions: []PositionRule{{Position: 0, Allow: Digits}}},
+	}
+	for i, recipe := range recipes {
+		abc := recipe.buildCharacterList()
+		want := bruteForceN(&recipe, abc)
+		if got := recipe.n().Int64(); got != want {
+			t.Errorf("%d: n() is %d, brute force gives %d", i, got, want)
+		}
+	}
+}
+
+func TestNRuns(t *testing.T) {
+	// Four different digits
+	recipe := &CharRecipe{Length: 4, Allow: Digits, NoRepeat: true}
+	recipe.buildCharacterList()
+	if got := recipe.n().Int64(); got != 10*9*8*7 {
+		t.Errorf("n() should be %d, was %d", 10*9*8*7, got)
+	}
+
+	recipes := []CharRecipe{
+		{Length: 4, AllowChars: "abc12", MaxConsecutive: 1},
+		{Length: 5, AllowChars: "ab1-", MaxConsecutive: 2},
+		{Length: 4, AllowChars: "abcd", MaxSequence: 2},
+		{Length: 5, AllowChars: "abc123-", MaxSequence: 1},
+		{Length: 5, AllowChars: "abcyz0", MaxSequence: 2, MaxConsecutive: 2},
+		{Length: 4, AllowChars: "abcde", NoRepeat: true, MaxConsecutive: 1},
+		{Length: 4, AllowChars: "ab-", Allow: Digits, NoRepeat: true, Require: Digits, ClassCounts: map[CTFlag]CountRange{Digits: {Min: 2}}},
+		{Length: 4, AllowChars: "ab", RequireSets: []string{"123", "3c"}, MaxSequence: 2, MaxConsecutive: 1},
+		{Length: 4, AllowChars: "abc", Allow: Digits, MaxSequence: 2, Positions: []PositionRule{{Position: 0, Allow: Digits}}},
+		// Impossible: not enough different characters
+		{Length: 4, AllowChars: "abc", NoRepeat: true},
+	}
+	for i, recipe := range recipes {
+		abc := recipe.buildCharacterList()
+		want := bruteForceN(&recipe, abc)
+		if got := recipe.n().Int64(); got != want {
+			t.Errorf("%d: n() is %d, brute force gives %d", i, got, want)
+		}
+	}
+
+	recipe = &CharRecipe{Length: 8, Allow: Digits, NoRepeat: true, MaxSequence: 2}
+	if e := recipe.Entropy(); !math.IsNaN(float64(e)) {
+		t.Errorf("NoRepeat with MaxSequence should have NaN entropy, not %f", e)
+	}
+	if _, err := recipe.Generate(); err == nil {
+		t.Error("NoRepeat with MaxSequence should not generate")
+	}
+}
+
 func TestEntropy(t *testing.T) {
 	recip := &CharRecipe{
 		Length:      2,
@@ -90,21 +297,15 @@ func TestEntropy(t *testing.T) {
 	}
 }
 
-func TestRandomUint32n_Panic(t *testing.T) {
-	// Testing recover state to check for panic.
-	// Lifted from https://stackoverflow.com/a/31596110/1304076
-	defer func() {
-		if r := recover(); r == nil {
-			t.Error("should have panicked")
-		}
-	}()
-
-	randomUint32n(0)
+func TestRandomUint32n_Zero(t *testing.T) {
+	if _, err := randomUint32n(nil, 0); err == nil {
+		t.Error("should have failed")
+	}
 }
 
 func TestRandomUint32n_1(t *testing.T) {
-	if r := randomUint32n(1); r != 0 {
-		t.Errorf("returned %v instead of 0", r)
+	if r, err := randomUint32n(nil, 1); r != 0 || err != nil {
+		t.Errorf("returned %v (%v) instead of 0", r, err)
 	}
 }
 
@@ -197,6 +398,39 @@ func TestSuccessProbability(t *testing.T) {
 	}
 }
 
+func TestRecipeTrialLimits(t *testing.T) {
+	all := []string{lower, upper, digits, symbols}
+	vectors := []struct {
+		length     int
+		trials     int
+		failRate   float64
+		acceptable bool
+	}{
+		{4, 0, 0, false},
+		{4, 1000, 0, true},
+		{5, 0, 0, true},
+		{5, 0, 1e-30, false},
+		{5, 50, 0, false},
+		{5, 1000, 1e-30, true},
+	}
+	for i, v := range vectors {
+		r := CharRecipe{Length: v.length, RequireSets: all, MaxTrials: v.trials, MaxFailRate: v.failRate}
+		c, err := r.Compile()
+		if err != nil {
+			t.Fatalf("%d: couldn't compile: %v", i, err)
+		}
+		if acceptable := c.trials > 0; acceptable != v.acceptable {
+			t.Errorf("%d: acceptable should be %v, success probability %v", i, v.acceptable, r.SuccessProbability())
+		}
+		if _, err := r.Generate(); err != nil {
+			t.Errorf("%d: failed to generate: %v", i, err)
+		}
+	}
+	if MaxTrials != 200 || MaxFailRate != 1.0/1000000000 {
+		t.Errorf("recipe limits should not change the defaults")
+	}
+}
+
 func TestAcceptableFailRate(t *testing.T) {
 	vectors := []tvec{{RequireSets: []string{lower, upper, digits, symbols}, Length: 1, P: 0.0, Acceptable: false},
 		{RequireSets: []string{lower, upper, digits, symbols}, Length: 2, P: 0.0, Acceptable: false},
@@ -217,22 +451,25 @@ func TestAcceptableFailRate(t *testing.T) {
 			RequireSets:  exp.RequireSets,
 			ExcludeChars: exp.ExcludeChars,
 		}
-		recipe.buildCharacterList()
-		acceptable, p := recipe.hasAcceptableFailRate()
+		c, err := recipe.Compile()
+		acceptable := err == nil && c.trials > 0
+		p := recipe.SuccessProbability()
 		if acceptable != exp.Acceptable {
 			if acceptable {
-				t.Errorf("%d-th incorrectly found acceptable: fail rate %v", i, p)
+				t.Errorf("%d-th incorrectly found acceptable: success probability %v", i, p)
 			} else {
-				t.Errorf("%d-th incorrectly found unacceptable: fail rate %v", i, p)
+				t.Errorf("%d-th incorrectly found unacceptable: success probability %v", i, p)
 			}
 		}
 
-		_, err := recipe.Generate()
-		if err == nil && !exp.Acceptable {
-			t.Errorf("%d-th should have reported error for too high failure rate. Computed %v", i, p)
+		// An unacceptable failure rate for rejection sampling no longer stops
+		// generation. Only impossible recipes should fail.
+		_, err = recipe.Generate()
+		if err == nil && exp.P == 0.0 {
+			t.Errorf("%d-th should have reported error for impossible requirements", i)
 		}
-		if err != nil && exp.Acceptable {
-			t.Errorf("%d-th shouldn't have reported error for acceptable failure rate. Computed:%v, Error: %q", i, p, err)
+		if err != nil && exp.P > 0.0 {
+			t.Errorf("%d-th shouldn't have reported error for possible requirements. Computed:%v, Error: %q", i, p, err)
 
 		}
 	}
@@ -6,6 +6,8 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"go.1password.io/spg"
@@ -33,14 +35,14 @@ var ccMap = map[string]spg.CTFlag{
 	"ambiguous": spg.Ambiguous,
 }
 
-var separatorMap = map[string]spg.SFFunction{
-	"hyphen":     createSeparatorFunc("-"),
-	"space":      createSeparatorFunc(" "),
-	"comma":      createSeparatorFunc(","),
-	"period":     createSeparatorFunc("."),
-	"underscore": createSeparatorFunc("_"),
-	"digit":      spg.SFDigits1,
-	"none":       spg.SFNone,
+var separatorMap = map[string]spg.Separator{
+	"hyphen":     spg.LiteralSeparator("-"),
+	"space":      spg.LiteralSeparator(" "),
+	"comma":      spg.LiteralSeparator(","),
+	"period":     spg.LiteralSeparator("."),
+	"underscore": spg.LiteralSeparator("_"),
+	"digit":      spg.SepDigits1,
+	"none":       spg.LiteralSeparator(""),
 }
 
 var capitalizeMap = map[string]spg.CapScheme{
@@ -52,10 +54,14 @@ var capitalizeMap = map[string]spg.CapScheme{
 }
 
 // Subcommands
-// var recipeCommand = flag.NewFlagSet("recipe", flag.ExitOnError)
+var recipeCommand = flag.NewFlagSet("recipe", flag.ExitOnError)
 var wordlistCommand = flag.NewFlagSet("words", flag.ExitOnError)
 var charactersCommand = flag.NewFlagSet("characters", flag.ExitOnError)
 
+// Recipe flags
+var flagRecipeFile = recipeCommand.String("file", "", "use a recipe file at the specified <path>")
+var flagEntropyRecipe = recipeCommand.Bool("entropy", false, "show the entropy of the password recipe")
+
 // Character flags
 var flagLength = charactersCommand.Int("length", defaultCharRecipe.length, "generate a password <n> characters in length")
 var flagAllow = charactersCommand.String("allow", "", "allow characters from <characterclasses>")
@@ -80,12 +86,8 @@ func main() {
 
 	var generator spg.Generator
 	switch os.Args[1] {
-	// case "recipe":
-	// 	recipeCommand.Parse(os.Args[2:])
-	// recipe := parseRecipe(*flagRecipe)
-
-	// pwd, _ := recipe.Generate()
-	// fmt.Println(pwd.String())
+	case "recipe":
+		generator = recipeGenerator(os.Args[2:])
 	case "characters":
 		if err := charactersCommand.Parse(os.Args[2:]); err != nil {
 			printUsage()
@@ -103,7 +105,7 @@ func main() {
 		os.Exit(ExitUsage)
 	}
 
-	if *flagEntropyWL || *flagEntropyCR {
+	if *flagEntropyWL || *flagEntropyCR || *flagEntropyRecipe {
 		fmt.Printf("%.2f\n", generator.Entropy())
 	} else {
 		pwd, err := generator.Generate()
@@ -116,12 +118,6 @@ func main() {
 	}
 }
 
-func createSeparatorFunc(value string) spg.SFFunction {
-	return func() (string, spg.FloatE) {
-		return value, 0
-	}
-}
-
 func parseCharacterClasses(value string, defaults []string) spg.CTFlag {
 	var ccFlags spg.CTFlag
 	var classes []string
@@ -145,13 +141,77 @@ func parseCharacterClasses(value string, defaults []string) spg.CTFlag {
 }
 
 func parseRecipe(value string) spg.Generator {
-	recipe, ok := recipes[value]
+	data, ok := recipes[value]
 	if !ok {
+		printUsage()
 		os.Exit(ExitUsage)
 	}
+	recipe, err := spg.ParseRecipe([]byte(data), nil)
+	if err != nil {
+		log.Fatalln("Error in recipe:", value, err)
+	}
 	return recipe
 }
 
+// recipeGenerator sets up a generator from the arguments to the recipe subcommand,
+// which are either the name of a preset or a --file, and maybe --entropy
+func recipeGenerator(args []string) spg.Generator {
+	if err := recipeCommand.Parse(args); err != nil {
+		printUsage()
+		os.Exit(ExitUsage)
+	}
+	name := ""
+	if recipeCommand.NArg() > 0 {
+		// flag stops at the first argument that isn't a flag
+		name = recipeCommand.Arg(0)
+		if err := recipeCommand.Parse(recipeCommand.Args()[1:]); err != nil {
+			printUsage()
+			os.Exit(ExitUsage)
+		}
+	}
+	if (name == "") == (*flagRecipeFile == "") {
+		printUsage()
+		os.Exit(ExitUsage)
+	}
+	if name != "" {
+		return parseRecipe(name)
+	}
+	return loadRecipeFile(*flagRecipeFile)
+}
+
+// loadRecipeFile reads a recipe from the JSON file at path. Word lists
+// that aren't built in are loaded from files, relative to the recipe file.
+func loadRecipeFile(path string) spg.Generator {
+	data, err := ioutil.ReadFile(path) // #nosec G304
+	if err != nil {
+		log.Fatalln("Error opening file:", path, err)
+	}
+	lists := func(name string) (*spg.WordList, error) {
+		if wl, err := spg.BuiltinWordList(name); err == nil {
+			return wl, nil
+		}
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(filepath.Dir(path), name)
+		}
+		return loadWordListFile(name), nil
+	}
+	recipe, err := spg.ParseRecipe(data, lists)
+	if err != nil {
+		log.Fatalln("Error in recipe file:", path, err)
+	}
+	return recipe
+}
+
+// recipeNames lists the presets
+func recipeNames() string {
+	names := make([]string, 0, len(recipes))
+	for name := range recipes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
 func parseWordList(value string) *spg.WordList {
 	var words []string
 	switch value {
@@ -172,7 +232,7 @@ func parseWordList(value string) *spg.WordList {
 	return wordList
 }
 
-func parseSeparator(value string) spg.SFFunction {
+func parseSeparator(value string) spg.Separator {
 	return separatorMap[value]
 }
 
@@ -189,7 +249,7 @@ func wlGenerator() *spg.WLRecipe {
 	}
 
 	recipe := spg.NewWLRecipe(*flagSize, wl)
-	recipe.SeparatorFunc = parseSeparator(*flagSeparator)
+	recipe.Separator = parseSeparator(*flagSeparator)
 	recipe.Capitalize = parseCapitalize(*flagCapitalize)
 
 	return recipe
@@ -210,24 +270,22 @@ func loadWordListFile(path string) *spg.WordList {
 		log.Fatalln("Error opening file:", path, err)
 	}
 
-	words := strings.Fields(string(data))
-	wordList, err := spg.NewWordList(words)
+	wordList, err := spg.ParseWordList(data)
 	if err != nil {
-		log.Fatalln("Error creating wordlist:", err)
+		log.Fatalln("Error creating wordlist:", path, err)
 	}
 	return wordList
 }
 
 func printUsage() {
+	fmt.Println(`
+opgen recipe [<recipe> | --file=<recipefile>] [--entropy]
 
-	// opgen recipe [<recipe> | --file=<recipefile>] [--entropy]
-
-	// --file      use a recipe file at the specified path
-	// --entropy   show the entropy of the password recipe
+	--file      use a JSON recipe file at the specified path
+	--entropy   show the entropy of the password recipe
 
-	// <recipe>: memorable, syllables, pin
+	<recipe>: ` + recipeNames() + `
 
-	fmt.Println(`
 opgen characters [--length=<n>] [--allow=<characterclasses>]
 				[--exclude=<characterclasses>] [--require=<characterclasses>]
 				[--entropy]
@@ -247,7 +305,8 @@ opgen words [--list=<wordlist> | --file=<wordlistfile>] [--size=<n>]
 				[--entropy]
 
 	--list         use built-in <wordlist> (default: words)
-	--file         use a wordlist file at the specified path
+	--file         use a wordlist file at the specified path, with words
+	               separated by white space or numbered by dice rolls (Diceware, EFF)
 	--size         generate a password with <n> elements (default: 4)
 	--separator    separate components with <separatorclass> (default: hyphen)
 	--capitalize   capitalize password according to <scheme> (default: none)
@@ -1,9 +1,5 @@
 package main
 
-import (
-	"go.1password.io/spg"
-)
-
 type charRecipe struct {
 	length  int
 	allow   []string
@@ -17,22 +13,14 @@ var defaultCharRecipe = charRecipe{
 	exclude: []string{"ambiguous"},
 }
 
-var recipes = map[string]spg.Generator{
-	"pin": &spg.CharRecipe{
-		Length: 4,
-		Allow:  spg.Digits,
-	},
-	"memorable": &spg.WLRecipe{
-		Length:        4,
-		SeparatorChar: "-",
-	},
-	"syllables": &spg.WLRecipe{
-		Length: 5,
-	},
+// recipes are the presets for the recipe subcommand,
+// in the same JSON format as recipe files
+var recipes = map[string]string{
+	"pin": `{"version": 1, "type": "characters", "length": 4, "allow": ["digits"]}`,
+	"memorable": `{"version": 1, "type": "words", "length": 4, "list": "words",
+		"separator": "-"}`,
+	"syllables": `{"version": 1, "type": "words", "length": 5, "list": "syllables"}`,
 	// compromise attempts to fix strength, memorability, and type-ability
-	"compromise": &spg.WLRecipe{
-		Length:        4,
-		Capitalize:    spg.CSOne,
-		SeparatorFunc: spg.SFDigits1,
-	},
+	"compromise": `{"version": 1, "type": "words", "length": 4, "list": "words",
+		"capitalize": "one", "separator": {"length": 1, "allow": ["digits"]}}`,
 }
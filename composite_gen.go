@@ -0,0 +1,90 @@
+package spg
+
+import "fmt"
+
+// CompositeRecipe is a recipe for passwords made of parts from other
+// generators, one after the other. For example, a few words from a WLRecipe
+// followed by four digits from a CharRecipe.
+//
+// The tokens of each part are kept as they are, with Separator (if not empty)
+// as a separator token between parts.
+//
+// Entropy is the sum of the entropies of the parts. That is only right if
+// a password can be split back into its parts. A Separator that the parts
+// can't produce, or parts of fixed lengths, make sure of that.
+type CompositeRecipe struct {
+	Parts     []Generator // Generators for each part, in order
+	Separator string      // What goes between parts
+
+	// Where random bytes come from. If nil, each part uses its own source.
+	// Otherwise it is used for all of the parts that have a GenerateFrom method
+	Source RandomSource
+}
+
+// sourcedGenerator is a Generator which can take its randomness from a RandomSource
+type sourcedGenerator interface {
+	Generator
+	GenerateFrom(rs RandomSource) (*Password, error)
+}
+
+// NewCompositeRecipe creates a CompositeRecipe for parts with separator between them
+func NewCompositeRecipe(separator string, parts ...Generator) *CompositeRecipe {
+	return &CompositeRecipe{Parts: parts, Separator: separator}
+}
+
+// Generate a password made from each of the parts of r
+func (r CompositeRecipe) Generate() (*Password, error) {
+	if r.Source == nil {
+		return r.generate(func(g Generator) (*Password, error) { return g.Generate() })
+	}
+	return r.GenerateFrom(r.Source)
+}
+
+// GenerateFrom is like Generate, but all parts that have a GenerateFrom method
+// draw their randomness from rs. A nil rs means crypto/rand.
+func (r CompositeRecipe) GenerateFrom(rs RandomSource) (*Password, error) {
+	return r.generate(func(g Generator) (*Password, error) {
+		if sg, ok := g.(sourcedGenerator); ok {
+			return sg.GenerateFrom(rs)
+		}
+		return g.Generate()
+	})
+}
+
+func (r CompositeRecipe) generate(gen func(g Generator) (*Password, error)) (*Password, error) {
+	if len(r.Parts) == 0 {
+		return nil, fmt.Errorf("%w: composite recipe has no parts", ErrInvalidRecipe)
+	}
+	p := &Password{}
+	for i, part := range r.Parts {
+		if part == nil {
+			return nil, fmt.Errorf("%w: part %d of composite recipe is nil", ErrInvalidRecipe, i)
+		}
+		pp, err := gen(part)
+		if err != nil {
+			return nil, fmt.Errorf("part %d: %w", i, err)
+		}
+		if i > 0 && r.Separator != "" {
+			p.tokens = append(p.tokens, Token{r.Separator, SeparatorType})
+		}
+		p.tokens = append(p.tokens, pp.Tokens()...)
+		p.Entropy += pp.Entropy
+	}
+	return p, nil
+}
+
+// Entropy returns the sum of the entropies of the parts
+func (r CompositeRecipe) Entropy() float32 {
+	var ent float32
+	for _, part := range r.Parts {
+		if part != nil {
+			ent += part.Entropy()
+		}
+	}
+	return ent
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
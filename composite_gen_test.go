@@ -0,0 +1,73 @@
+package spg
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestCompositeRecipe(t *testing.T) {
+	wl, err := NewWordList([]string{"one", "two", "three", "four"})
+	if err != nil {
+		t.Fatalf("failed to create word list: %v", err)
+	}
+	words := NewWLRecipe(3, wl)
+	words.SeparatorChar = "-"
+	digits := &CharRecipe{Length: 4, Allow: Digits}
+
+	r := NewCompositeRecipe(".", words, digits)
+	r.Source = newDetSource("composite")
+	if got, want := r.Entropy(), words.Entropy()+digits.Entropy(); got != want {
+		t.Errorf("entropy should be %f, was %f", want, got)
+	}
+
+	p, err := r.Generate()
+	if err != nil {
+		t.Fatalf("failed to generate: %v", err)
+	}
+	if !regexp.MustCompile(`^[a-z]+-[a-z]+-[a-z]+\.[0-9]{4}$`).MatchString(p.String()) {
+		t.Errorf("%q doesn't look right", p)
+	}
+	if p.Entropy != r.Entropy() {
+		t.Errorf("password entropy (%f) should be recipe entropy (%f)", p.Entropy, r.Entropy())
+	}
+
+	// word - word - word . d d d d
+	toks := p.Tokens()
+	if len(toks) != 10 {
+		t.Fatalf("%q should have 10 tokens, not %d", p, len(toks))
+	}
+	if toks[5].Value() != "." || toks[5].Type() != SeparatorType {
+		t.Errorf("token 5 of %q should be the separator, not %q", p, toks[5].Value())
+	}
+
+	// The token structure survives indices
+	ti, err := toks.MakeIndices()
+	if err != nil {
+		t.Fatalf("couldn't make indices: %v", err)
+	}
+	q, err := Tokenize(p.String(), ti, p.Entropy)
+	if err != nil {
+		t.Fatalf("couldn't tokenize: %v", err)
+	}
+	if len(q.Tokens()) != len(toks) {
+		t.Errorf("tokenizing gave %d tokens, not %d", len(q.Tokens()), len(toks))
+	}
+
+	// The same source gives the same password
+	again, _ := r.GenerateFrom(newDetSource("composite"))
+	if again.String() != p.String() {
+		t.Errorf("same source gave %q and %q", p, again)
+	}
+
+	if _, err := (CompositeRecipe{}).Generate(); err == nil {
+		t.Error("composite with no parts should not generate")
+	}
+	if _, err := NewCompositeRecipe("", digits, &CharRecipe{}).Generate(); err == nil {
+		t.Error("composite with a bad part should not generate")
+	}
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
@@ -21,6 +21,16 @@ or a word (or syllable) list recipe, r, with NewWLRecipe().
 3. The returned password, pwd, has a String() method, which does the obvious thing and
 Entropy field, which contains the min-Entropy based on the recipe.
 
+Randomness
+
+By default all randomness comes from crypto/rand. Each recipe has a Source field,
+and a GenerateFrom method, that take a RandomSource instead. That is, any io.Reader.
+This is for plugging in things like HSM-backed readers or DRBGs. A deterministic
+source is handy for reproducible tests, but must never be used for real passwords.
+If a source fails, Generate returns an error that wraps ErrRandomSource.
+NewBufferedSource reads a source in blocks rather than a few bytes for each
+character or word.
+
 Wordlist and pronounceable
 
 The word list generator produces things like "correct horse battery staple", but
@@ -36,15 +46,46 @@ Lengths for these are specified in terms of the number of elements drawn from th
 list to be included in these passwords (not counting the separators).
 Although the above examples all have different lengths in terms of number of characters,
 they were all specified as Length 4.
+Where a site limits the number of characters, MinChars and MaxChars on the recipe
+bound the whole password, separators included; the entropy reported is then exact
+for the passwords that fit.
 
 The passwords that one gets depend on the word list recipe, WLRecipe, and the actual
 word list provided.
+ParseWordList reads plain lists of words separated by white space, and Diceware
+or EFF style lists numbered by dice rolls.
+Smaller lists can be derived from a WordList with Filter, FilterLength and FilterAlphabet.
+What goes between the words is a Separator: a LiteralSeparator, or a CharSeparator
+for things like the digits above. SepDigits1 and the other presets are CharSeparators.
 
 Character passwords
 
 Character-based are your typical notion of generated password,
 however these can be specified in ways to produce only numeric PINs if desired.
 The passwords generated are a function of the CharRecipe.
+To generate many passwords from one recipe, Compile it once. The CompiledCharRecipe
+does the expensive work up front and is safe to share between goroutines.
+
+Regular expression passwords
+
+For rules that a CharRecipe can't express, a RegexRecipe generates passwords
+uniformly from all of the strings, within a range of lengths, that match a
+regular expression. It can be compiled too, so that its automaton is only built once.
+
+Pattern passwords
+
+A PatternRecipe fills in a template such as "Cvccvc-99-Cvccvc", for formats that are fixed
+by some vendor or other.
+
+Saving recipes
+
+Character and word list recipes can be saved to, and loaded from, a versioned JSON
+format with encoding/json. ParseRecipe loads either kind.
+
+Composite passwords
+
+A CompositeRecipe puts together the passwords of other generators, say a few words
+followed by four digits, keeping the tokens of each part.
 
 The Generate and Entropy methods
 
@@ -68,11 +109,19 @@ It does, however, make sense when a password is drawn uniformly from a space of
 When the distribution is uniform, the (Shannon) entropy is the same as the min-entropy (based on probability of getting the most likely result).
 
 This package does ensure that passwords are generated uniformly given the recipe
-passed to the generator, with the exception of the interaction of capitalizaton for some wordlists.
+passed to the generator, with the exception of the interaction of capitalizaton for some wordlists,
+and of weighted word lists from NewWeightedWordList, which draw some words more often than others.
 In those cases, min-entropy is reported. That is, where min-entropy is not the same as Shannon Entropy Entropy() returns the min-entropy.
+For weighted lists, WLRecipe.ShannonEntropy gives the Shannon entropy as well.
 
 Entropy is a function solely of the recipe.
 
+Errors
+
+Errors from the package wrap one of the Err values, such as ErrEmptyAlphabet or
+ErrMalformedIndices, so check for them with errors.Is. Validate on a recipe says
+what keeps it from producing passwords with a *RecipeError, which errors.As can get at.
+
 License
 
 This package is Copyright 2017, 2018 by AgileBits, Inc and is licensed under the Apache 2.0 agreement.
@@ -0,0 +1,195 @@
+package spg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors returned by the package. Most come wrapped with details, so check
+// for them with errors.Is. A *RecipeError is each of the errors for the kind
+// of problem it describes.
+var (
+	ErrBadLength              = errors.New("bad password length")
+	ErrEmptyAlphabet          = errors.New("no characters to build pwd from")
+	ErrImpossibleRequirements = errors.New("requirements can't be met")
+	ErrUnsupported            = errors.New("unsupported")
+	ErrInvalidRecipe          = errors.New("invalid recipe")
+	ErrSyntax                 = errors.New("syntax error")
+	ErrNoWordList             = errors.New("no word list")
+	ErrTooFewWords            = errors.New("too few words")
+	ErrTooManyWords           = errors.New("too many words")
+	ErrBadWeight              = errors.New("bad word weight")
+	ErrTokenTooLong           = errors.New("token too long")
+	ErrMalformedIndices       = errors.New("malformed indices")
+	ErrIndexOutOfRange        = errors.New("index out of range")
+	ErrNotFromRecipe          = errors.New("password could not have been generated by the recipe")
+	ErrRandomSource           = errors.New("can't get random bytes")
+	ErrInternal               = errors.New("internal error")
+)
+
+// errCounting is for when unranking runs out of choices before it has
+// used up its index. That can only happen if the counts that it goes by
+// are wrong, which would be a bug here rather than a problem with the recipe.
+func errCounting(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: counts are inconsistent: %s", ErrInternal, fmt.Sprintf(format, args...))
+}
+
+// Problem is a kind of problem that keeps a recipe from producing passwords
+type Problem int
+
+// Problems that Validate can find
+const (
+	ProblemBadLength        Problem = iota + 1 // Length is less than 1
+	ProblemEmptyAlphabet                       // There are no characters to make passwords from
+	ProblemEmptyRequiredSet                    // A required set has no characters left, usually because of Exclude
+	ProblemTooShort                            // Length is less than what the requirements need
+	ProblemBadBounds                           // A minimum count is more than the maximum
+	ProblemEmptyPosition                       // Nothing may appear at some position
+	ProblemTooFewCharacters                    // NoRepeat with more characters than the alphabet has
+	ProblemUnsupported                         // A combination of settings that can't be used, or too many overlapping RequireSets
+	ProblemImpossible                          // Requirements that can't all be met together
+	ProblemNoWordList                          // A word list recipe without a word list
+	ProblemWordListTooSmall                    // Too few of the words on the list can be used to be of any use
+)
+
+// RecipeError describes a problem that keeps a recipe from producing passwords.
+// Problem says what kind of problem it is. The other fields give details
+// where they apply.
+type RecipeError struct {
+	Problem  Problem
+	Set      string // Name of the set or class with the problem
+	Length   int    // Length of the recipe
+	Need     int    // What would be needed, such as the shortest workable length
+	Position int    // Position in the password with the problem
+	Size     int    // Number of words on the list that can be used
+	Detail   string // Anything else worth saying
+}
+
+func (e *RecipeError) Error() string {
+	switch e.Problem {
+	case ProblemBadLength:
+		return fmt.Sprintf("don't ask for passwords of length %d", e.Length)
+	case ProblemEmptyAlphabet:
+		return "no characters to build pwd from"
+	case ProblemEmptyRequiredSet:
+		return fmt.Sprintf("required %s has no characters left after exclusions", e.Set)
+	case ProblemTooShort:
+		return fmt.Sprintf("length %d is too short for the requirements, which need at least %d characters", e.Length, e.Need)
+	case ProblemBadBounds:
+		return fmt.Sprintf("%s: %s", e.Set, e.Detail)
+	case ProblemEmptyPosition:
+		return fmt.Sprintf("no character may appear at position %d", e.Position)
+	case ProblemTooFewCharacters:
+		return fmt.Sprintf("length %d is more than the %d different characters available without repeats", e.Length, e.Need)
+	case ProblemUnsupported:
+		return e.Detail
+	case ProblemImpossible:
+		return fmt.Sprintf("no password of length %d can meet the requirements (%s)", e.Length, e.Detail)
+	case ProblemNoWordList:
+		return "wordlist generator must be set up before being used"
+	case ProblemWordListTooSmall:
+		return fmt.Sprintf("only %d words on the list can be used, it needs at least %d", e.Size, e.Need)
+	}
+	return "problem with recipe"
+}
+
+// Is reports whether target is the package error for the kind of problem e is,
+// so that errors.Is(err, ErrImpossibleRequirements) works for all of the
+// ways requirements can fail.
+func (e *RecipeError) Is(target error) bool {
+	switch e.Problem {
+	case ProblemBadLength:
+		return target == ErrBadLength
+	case ProblemEmptyAlphabet:
+		return target == ErrEmptyAlphabet
+	case ProblemEmptyRequiredSet, ProblemTooShort, ProblemBadBounds, ProblemEmptyPosition,
+		ProblemTooFewCharacters, ProblemImpossible:
+		return target == ErrImpossibleRequirements
+	case ProblemUnsupported:
+		return target == ErrUnsupported
+	case ProblemNoWordList:
+		return target == ErrNoWordList
+	case ProblemWordListTooSmall:
+		return target == ErrTooFewWords
+	}
+	return false
+}
+
+// Validate checks that r can produce passwords. If it can't, the error is a
+// *RecipeError naming the first problem found. Among them, too many required
+// sets linked by shared characters to count (see CharRecipe) are ProblemUnsupported.
+func (r CharRecipe) Validate() error {
+	if r.Length < 1 {
+		return &RecipeError{Problem: ProblemBadLength, Length: r.Length}
+	}
+	if err := r.runsError(); err != nil {
+		return &RecipeError{Problem: ProblemUnsupported, Detail: err.Error()}
+	}
+
+	abc := r.buildCharacterList()
+	for _, rs := range r.requiredSets {
+		if rs.min > 0 && rs.size() == 0 {
+			return &RecipeError{Problem: ProblemEmptyRequiredSet, Set: rs.Name}
+		}
+	}
+	if len(abc) == 0 {
+		return &RecipeError{Problem: ProblemEmptyAlphabet}
+	}
+	for _, rs := range r.requiredSets {
+		if rs.max > 0 && rs.min > rs.max {
+			return &RecipeError{Problem: ProblemBadBounds, Set: rs.Name,
+				Detail: fmt.Sprintf("at least %d but no more than %d", rs.min, rs.max)}
+		}
+		if r.NoRepeat && rs.min > rs.size() {
+			return &RecipeError{Problem: ProblemBadBounds, Set: rs.Name,
+				Detail: fmt.Sprintf("at least %d without repeats, but it only has %d characters", rs.min, rs.size())}
+		}
+	}
+	for pos, ps := range r.positionSets {
+		if ps != nil && ps.Cardinality() == 0 {
+			return &RecipeError{Problem: ProblemEmptyPosition, Position: pos}
+		}
+	}
+	if r.NoRepeat && r.Length > len(abc) {
+		return &RecipeError{Problem: ProblemTooFewCharacters, Length: r.Length, Need: len(abc)}
+	}
+	if need := r.requiredSets.minLength(); need > r.Length {
+		return &RecipeError{Problem: ProblemTooShort, Length: r.Length, Need: need}
+	}
+	if err := r.overlapError(); err != nil {
+		return err
+	}
+	if r.n().Sign() == 0 {
+		return &RecipeError{Problem: ProblemImpossible, Length: r.Length, Detail: strings.Join(r.requiredSets.names(), ", ")}
+	}
+	return nil
+}
+
+// Validate checks that r can produce passwords. If it can't, the error is a
+// *RecipeError naming the problem.
+func (r WLRecipe) Validate() error {
+	if r.list == nil || r.list.Size() == 0 {
+		return &RecipeError{Problem: ProblemNoWordList}
+	}
+	if r.Length < 1 {
+		return &RecipeError{Problem: ProblemBadLength, Length: r.Length}
+	}
+	if err := r.charsError(); err != nil {
+		return err
+	}
+	var wc *wordCounter
+	if r.limitsChars() {
+		wc = r.newWordCounter()
+		if wc.total().Sign() == 0 {
+			return &RecipeError{Problem: ProblemImpossible, Length: r.Length,
+				Detail: fmt.Sprintf("no %d words fit in %d to %d characters", r.Length, r.MinChars, r.MaxChars)}
+		}
+	}
+	return r.sizeError(wc)
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
@@ -0,0 +1,198 @@
+package spg
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestCharRecipeValidate(t *testing.T) {
+	disjoint := []string{"abc", "def", "ghi"}
+	vectors := []struct {
+		name    string
+		r       CharRecipe
+		problem Problem
+		check   func(e *RecipeError) bool
+	}{
+		{"zero length", CharRecipe{Length: 0, Allow: Letters}, ProblemBadLength,
+			func(e *RecipeError) bool { return e.Length == 0 }},
+		{"excluded requirement", CharRecipe{Length: 10, Allow: Letters, Require: Symbols, Exclude: Symbols}, ProblemEmptyRequiredSet,
+			func(e *RecipeError) bool { return e.Set == "Symbols" }},
+		{"nothing allowed", CharRecipe{Length: 10, Allow: Digits, Exclude: Digits}, ProblemEmptyAlphabet, nil},
+		{"too many required sets", CharRecipe{Length: 2, RequireSets: disjoint}, ProblemTooShort,
+			func(e *RecipeError) bool { return e.Length == 2 && e.Need == 3 }},
+		{"minimums too long", CharRecipe{Length: 5, Allow: Letters, ClassCounts: map[CTFlag]CountRange{Digits: {Min: 6}}}, ProblemTooShort,
+			func(e *RecipeError) bool { return e.Need == 6 }},
+		{"min above max", CharRecipe{Length: 10, Allow: Letters, ClassCounts: map[CTFlag]CountRange{Digits: {Min: 5, Max: 3}}}, ProblemBadBounds,
+			func(e *RecipeError) bool { return e.Set == "Digits" }},
+		{"empty position", CharRecipe{Length: 4, Allow: Digits, Positions: []PositionRule{{Position: 2, Allow: Uppers}}}, ProblemEmptyPosition,
+			func(e *RecipeError) bool { return e.Position == 2 }},
+		{"no repeat too long", CharRecipe{Length: 11, Allow: Digits, NoRepeat: true}, ProblemTooFewCharacters,
+			func(e *RecipeError) bool { return e.Need == 10 }},
+		{"no repeat with sequences", CharRecipe{Length: 8, Allow: Digits, NoRepeat: true, MaxSequence: 2}, ProblemUnsupported, nil},
+		{"can't fit together", CharRecipe{Length: 3, Allow: Digits, RequireSets: []string{"12", "23"},
+			SetCounts: []CountRange{{Min: 2}, {Max: 1}}, ClassCounts: map[CTFlag]CountRange{Digits: {Max: 2}}}, ProblemImpossible, nil},
+	}
+
+	for _, v := range vectors {
+		err := v.r.Validate()
+		var re *RecipeError
+		if !errors.As(err, &re) {
+			t.Errorf("%s: expected a *RecipeError, got %v", v.name, err)
+			continue
+		}
+		if re.Problem != v.problem {
+			t.Errorf("%s: expected problem %d, got %d (%v)", v.name, v.problem, re.Problem, re)
+			continue
+		}
+		if v.check != nil && !v.check(re) {
+			t.Errorf("%s: wrong details in %+v", v.name, re)
+		}
+		if re.Error() == "" {
+			t.Errorf("%s: empty error message", v.name)
+		}
+
+		// Generate should report the same problem
+		if _, err := v.r.Generate(); err == nil || err.Error() != re.Error() {
+			t.Errorf("%s: Generate should fail with %q, got %v", v.name, re, err)
+		}
+	}
+
+	good := []CharRecipe{
+		*NewCharRecipe(20),
+		{Length: 3, RequireSets: disjoint},
+		{Length: 10, Allow: Digits, NoRepeat: true},
+		{Length: 4, Allow: Letters, Positions: []PositionRule{{Position: 0, Allow: Uppers}}},
+	}
+	for _, r := range good {
+		if err := r.Validate(); err != nil {
+			t.Errorf("%+v should be valid, got %v", r, err)
+		}
+	}
+}
+
+func TestWLRecipeValidate(t *testing.T) {
+	one, err := NewWordList([]string{"lonely"})
+	if err != nil {
+		t.Fatalf("couldn't make word list: %v", err)
+	}
+	three, err := NewWordList([]string{"one", "two", "three"})
+	if err != nil {
+		t.Fatalf("couldn't make word list: %v", err)
+	}
+
+	vectors := []struct {
+		r       WLRecipe
+		problem Problem
+	}{
+		{WLRecipe{Length: 3}, ProblemNoWordList},
+		{*NewWLRecipe(0, three), ProblemBadLength},
+	}
+	for _, v := range vectors {
+		var re *RecipeError
+		if err := v.r.Validate(); !errors.As(err, &re) || re.Problem != v.problem {
+			t.Errorf("expected problem %d, got %v", v.problem, err)
+		}
+	}
+	if _, err := vectors[0].r.Generate(); err == nil {
+		t.Errorf("generating without a word list should fail")
+	}
+
+	// A list of one word is no use, whether it started that way or was filtered down
+	short, err := three.FilterLength(5, 5)
+	if err != nil {
+		t.Fatalf("couldn't filter: %v", err)
+	}
+	for _, wl := range []*WordList{one, short} {
+		lonely := NewWLRecipe(3, wl)
+		var re *RecipeError
+		if err := lonely.Validate(); !errors.As(err, &re) || re.Problem != ProblemWordListTooSmall || re.Size != 1 {
+			t.Errorf("expected a list of one word to be too small, got %v", err)
+		}
+		if _, err := lonely.Generate(); !errors.Is(err, ErrTooFewWords) {
+			t.Errorf("generating from a list of one word should fail with ErrTooFewWords, got %v", err)
+		}
+		if _, err := lonely.Unrank(big.NewInt(0)); !errors.Is(err, ErrTooFewWords) {
+			t.Errorf("unranking from a list of one word should fail with ErrTooFewWords, got %v", err)
+		}
+		if e := lonely.Entropy(); !math.IsNaN(float64(e)) {
+			t.Errorf("entropy from a list of one word should be NaN, not %f", e)
+		}
+	}
+
+	if err := NewWLRecipe(3, three).Validate(); err != nil {
+		t.Errorf("recipe should be valid, got %v", err)
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	three, err := NewWordList([]string{"one", "two", "three"})
+	if err != nil {
+		t.Fatalf("couldn't make word list: %v", err)
+	}
+	r := NewCharRecipe(8)
+
+	vectors := []struct {
+		name   string
+		err    func() error
+		target error
+	}{
+		{"char length", func() error { _, err := (CharRecipe{Length: 0, Allow: Digits}).Generate(); return err }, ErrBadLength},
+		{"char alphabet", func() error { _, err := (CharRecipe{Length: 5}).Generate(); return err }, ErrEmptyAlphabet},
+		{"char requirements", func() error {
+			_, err := (CharRecipe{Length: 5, Allow: Digits, Require: Symbols, Exclude: Symbols}).Generate()
+			return err
+		}, ErrImpossibleRequirements},
+		{"char runs", func() error {
+			_, err := (CharRecipe{Length: 5, Allow: Digits, NoRepeat: true, MaxSequence: 2}).Generate()
+			return err
+		}, ErrUnsupported},
+		{"char unrank", func() error { _, err := r.Unrank(big.NewInt(-1)); return err }, ErrIndexOutOfRange},
+		{"char unrank nil", func() error { _, err := r.Unrank(nil); return err }, ErrIndexOutOfRange},
+		{"char rank", func() error {
+			_, err := r.Rank(&Password{tokens: Tokens{{"#", AtomType}}})
+			return err
+		}, ErrNotFromRecipe},
+		{"empty word list", func() error { _, err := NewWordList(nil); return err }, ErrTooFewWords},
+		{"no word list", func() error { _, err := (WLRecipe{Length: 3}).Generate(); return err }, ErrNoWordList},
+		{"word length", func() error { _, err := NewWLRecipe(0, three).Generate(); return err }, ErrBadLength},
+		{"word rank", func() error {
+			_, err := NewWLRecipe(1, three).Rank(&Password{tokens: Tokens{{"four", AtomType}}})
+			return err
+		}, ErrNotFromRecipe},
+		{"word unrank", func() error { _, err := NewWLRecipe(1, three).Unrank(big.NewInt(3)); return err }, ErrIndexOutOfRange},
+		{"word unrank nil", func() error { _, err := NewWLRecipe(1, three).Unrank(nil); return err }, ErrIndexOutOfRange},
+		{"builtin list", func() error { _, err := BuiltinWordList("nope"); return err }, ErrNoWordList},
+		{"password rules", func() error { _, err := ParsePasswordRules("required: unicode"); return err }, ErrUnsupported},
+		{"password rules syntax", func() error { _, err := ParsePasswordRules("required: [abc"); return err }, ErrSyntax},
+		{"regex", func() error { _, err := NewRegexRecipe("[a-", 1, 3).Generate(); return err }, ErrSyntax},
+		{"regex impossible", func() error { _, err := NewRegexRecipe("abcd", 1, 3).Generate(); return err }, ErrImpossibleRequirements},
+		{"pattern", func() error { _, err := NewPatternRecipe(`AAA\`).Generate(); return err }, ErrSyntax},
+		{"recipe type", func() error { _, err := ParseRecipe([]byte(`{"version": 1, "type": "emoji"}`), nil); return err }, ErrInvalidRecipe},
+		{"recipe syntax", func() error { _, err := ParseRecipe([]byte(`{"version": 1,`), nil); return err }, ErrSyntax},
+		{"recipe field type", func() error {
+			_, err := ParseRecipe([]byte(`{"version": 1, "type": "characters", "length": "8"}`), nil)
+			return err
+		}, ErrInvalidRecipe},
+		{"char recipe json", func() error { var c CharRecipe; return json.Unmarshal([]byte(`{"length": []}`), &c) }, ErrInvalidRecipe},
+		{"word recipe json", func() error { var w WLRecipe; return w.UnmarshalJSON([]byte(`{"version"`)) }, ErrSyntax},
+		{"composite", func() error {
+			_, err := NewCompositeRecipe("-", r, CharRecipe{Length: 2}).Generate()
+			return err
+		}, ErrEmptyAlphabet},
+		{"internal", func() error { return errCounting("ran out of characters at position %d", 3) }, ErrInternal},
+		{"intersect", func() error { _, err := IntersectRecipes(*NewCharRecipe(8), *NewCharRecipe(9)); return err }, ErrImpossibleRequirements},
+	}
+	for _, v := range vectors {
+		if err := v.err(); !errors.Is(err, v.target) {
+			t.Errorf("%s: expected %v, got %v", v.name, v.target, err)
+		}
+	}
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
@@ -0,0 +1,176 @@
+package spg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	set "github.com/deckarep/golang-set"
+)
+
+// IntersectionError explains why no recipe can satisfy all of the recipes
+// passed to IntersectRecipes
+type IntersectionError struct {
+	Reasons []string // Each thing that can't be satisfied
+}
+
+func (e *IntersectionError) Error() string {
+	return "recipes can't all be satisfied: " + strings.Join(e.Reasons, "; ")
+}
+
+// Is makes errors.Is(err, ErrImpossibleRequirements) true for an IntersectionError
+func (e *IntersectionError) Is(target error) bool {
+	return target == ErrImpossibleRequirements
+}
+
+// IntersectRecipes returns the most permissive recipe whose passwords meet all
+// of recipes. Its alphabet is what all of them allow, it has all of their
+// requirements, bounds, position rules and limits on runs, and its length is the
+// length they share.
+//
+// If there is no such recipe, or it can't produce any passwords, the error is an
+// *IntersectionError that says why.
+func IntersectRecipes(recipes ...CharRecipe) (*CharRecipe, error) {
+	if len(recipes) == 0 {
+		return nil, fmt.Errorf("%w: no recipes to intersect", ErrInvalidRecipe)
+	}
+	var reasons []string
+
+	// Each recipe is a fixed length, so they must all be the same
+	length := recipes[0].Length
+	for _, r := range recipes[1:] {
+		if r.Length != length {
+			lengths := make([]string, len(recipes))
+			for i, r := range recipes {
+				lengths[i] = fmt.Sprint(r.Length)
+			}
+			reasons = append(reasons, "lengths differ ("+strings.Join(lengths, ", ")+")")
+			break
+		}
+	}
+
+	// Characters that every recipe allows, and everything any of them mention
+	var alphabet set.Set
+	mentioned := setFromString(charsOfFlags(All | Ambiguous))
+	for i := range recipes {
+		r := recipes[i] // so that we don't change the caller's computed sets
+		abc := setFromString(strings.Join(r.buildCharacterList(), ""))
+		abc.Remove("")
+		if alphabet == nil {
+			alphabet = abc
+		} else {
+			alphabet = alphabet.Intersect(abc)
+		}
+		mentioned = mentioned.Union(setFromString(r.AllowChars + strings.Join(r.RequireSets, "")))
+	}
+	mentioned.Remove("")
+	if alphabet.Cardinality() == 0 {
+		reasons = append(reasons, "no character is allowed by all of the recipes")
+	}
+
+	// Requirements that nothing left can meet
+	for i := range recipes {
+		r := recipes[i]
+		r.buildCharacterList()
+		for _, rs := range r.requiredSets {
+			if rs.min > 0 && rs.s.Intersect(alphabet).Cardinality() == 0 && alphabet.Cardinality() > 0 {
+				reasons = append(reasons, fmt.Sprintf("%s, required by recipe %d, has no characters that all of the recipes allow", rs.Name, i+1))
+			}
+		}
+	}
+
+	out := &CharRecipe{Length: length}
+
+	// The alphabet is described as whole character types where we can,
+	// and everything else mentioned by any recipe is excluded
+	rest := alphabet.Clone()
+	for _, f := range []CTFlag{Uppers, Lowers, Digits, Symbols} {
+		ct := setFromString(charTypeByFlag[f])
+		if ct.IsSubset(alphabet) {
+			out.Allow |= f
+			rest = rest.Difference(ct)
+		}
+	}
+	out.AllowChars = sortedChars(rest)
+	out.ExcludeChars = sortedChars(mentioned.Difference(alphabet))
+
+	for _, r := range recipes {
+		out.Require |= r.Require
+		for j, s := range r.RequireSets {
+			out.RequireSets = append(out.RequireSets, s)
+			cr := CountRange{}
+			if j < len(r.SetCounts) {
+				cr = r.SetCounts[j]
+			}
+			out.SetCounts = append(out.SetCounts, cr)
+		}
+		for _, f := range sortedFlags(r.ClassCounts) {
+			if out.ClassCounts == nil {
+				out.ClassCounts = make(map[CTFlag]CountRange)
+			}
+			out.ClassCounts[f] = mergeCounts(out.ClassCounts[f], r.ClassCounts[f])
+		}
+		out.Positions = append(out.Positions, r.Positions...)
+		out.NoRepeat = out.NoRepeat || r.NoRepeat
+		out.MaxConsecutive = minPositive(out.MaxConsecutive, r.MaxConsecutive)
+		out.MaxSequence = minPositive(out.MaxSequence, r.MaxSequence)
+	}
+	for _, f := range sortedFlags(out.ClassCounts) {
+		if cr := out.ClassCounts[f]; cr.Max > 0 && cr.Min > cr.Max {
+			reasons = append(reasons, fmt.Sprintf("at least %d but no more than %d %s", cr.Min, cr.Max, ctName(f)))
+		}
+	}
+	// Trimming trailing empty bounds keeps the recipe tidy
+	for len(out.SetCounts) > 0 && out.SetCounts[len(out.SetCounts)-1] == (CountRange{}) {
+		out.SetCounts = out.SetCounts[:len(out.SetCounts)-1]
+	}
+	if err := out.runsError(); err != nil {
+		reasons = append(reasons, err.Error())
+	}
+
+	// Even when each part can be met, they may not all fit together
+	if len(reasons) == 0 && out.Length > 0 {
+		check := *out
+		check.buildCharacterList()
+		if err := check.overlapError(); err != nil {
+			reasons = append(reasons, err.Error())
+		} else if check.n().Sign() == 0 {
+			reasons = append(reasons, fmt.Sprintf("no password of length %d meets all of the requirements (%s)",
+				out.Length, strings.Join(check.requiredSets.names(), ", ")))
+		}
+	}
+
+	if len(reasons) > 0 {
+		return nil, &IntersectionError{Reasons: reasons}
+	}
+	return out, nil
+}
+
+// mergeCounts is the bounds that meet both a and b
+func mergeCounts(a, b CountRange) CountRange {
+	out := CountRange{Min: a.Min, Max: minPositive(a.Max, b.Max)}
+	if b.Min > out.Min {
+		out.Min = b.Min
+	}
+	return out
+}
+
+// minPositive is the smaller of a and b, where 0 means no limit
+func minPositive(a, b int) int {
+	if a == 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// sortedChars returns the characters in s, sorted, as a string
+func sortedChars(s set.Set) string {
+	chars := strings.Split(stringFromSet(s), "")
+	sort.Strings(chars)
+	return strings.Join(chars, "")
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
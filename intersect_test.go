@@ -0,0 +1,105 @@
+package spg
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// meetsRecipe checks that pw could have come from r
+func meetsRecipe(r CharRecipe, pw string) bool {
+	abc := r.Alphabet()
+	for _, c := range strings.Split(pw, "") {
+		if !strings.Contains(abc, c) {
+			return false
+		}
+	}
+	r.buildCharacterList()
+	return r.accepts(pw)
+}
+
+func TestIntersectRecipes(t *testing.T) {
+	a := *NewCharRecipe(16)
+	a.Require = Uppers
+	b := CharRecipe{Length: 16, Allow: Letters | Digits, Require: Digits, MaxConsecutive: 2, ExcludeChars: "xyz"}
+	c, err := ParsePasswordRules("minlength: 12; maxlength: 16; required: lower; allowed: upper, digit, [-]")
+	if err != nil {
+		t.Fatalf("couldn't parse rules: %v", err)
+	}
+
+	r, err := IntersectRecipes(a, b, *c)
+	if err != nil {
+		t.Fatalf("couldn't intersect: %v", err)
+	}
+	want := subtractString(ctUpper+ctLower+ctDigits, ctAmbiguous+"xyz")
+	if got := r.Alphabet(); got != sortedString(want) {
+		t.Errorf("alphabet should be %q, was %q", sortedString(want), got)
+	}
+	if r.MaxConsecutive != 2 || r.Length != 16 {
+		t.Errorf("wrong recipe: %+v", r)
+	}
+
+	r.Source = newDetSource("intersect")
+	for i := 0; i < 20; i++ {
+		p, err := r.Generate()
+		if err != nil {
+			t.Fatalf("failed to generate: %v", err)
+		}
+		for j, q := range []CharRecipe{a, b, *c} {
+			if !meetsRecipe(q, p.String()) {
+				t.Errorf("%q doesn't meet recipe %d", p, j+1)
+			}
+		}
+	}
+
+	// Bounds and runs are combined too
+	a = CharRecipe{Length: 8, Allow: Digits | Lowers, ClassCounts: map[CTFlag]CountRange{Digits: {Min: 2}}, MaxSequence: 3}
+	b = CharRecipe{Length: 8, Allow: Digits | Lowers, ClassCounts: map[CTFlag]CountRange{Digits: {Max: 6}}, MaxSequence: 2}
+	r, err = IntersectRecipes(a, b)
+	if err != nil {
+		t.Fatalf("couldn't intersect: %v", err)
+	}
+	if r.ClassCounts[Digits] != (CountRange{Min: 2, Max: 6}) || r.MaxSequence != 2 {
+		t.Errorf("wrong recipe: %+v", r)
+	}
+}
+
+func TestIntersectRecipesErrors(t *testing.T) {
+	vecs := []struct {
+		recipes []CharRecipe
+		reason  string
+	}{
+		{[]CharRecipe{{Length: 8, Allow: Digits}, {Length: 10, Allow: Digits}}, "lengths differ (8, 10)"},
+		{[]CharRecipe{{Length: 8, Allow: Digits}, {Length: 8, Allow: Letters}}, "no character is allowed"},
+		{[]CharRecipe{{Length: 8, Allow: Letters, Require: Symbols}, {Length: 8, Allow: Letters}}, "Symbols, required by recipe 1"},
+		{[]CharRecipe{
+			{Length: 8, Allow: Digits, ClassCounts: map[CTFlag]CountRange{Digits: {Min: 5}}},
+			{Length: 8, Allow: Digits | Letters, ClassCounts: map[CTFlag]CountRange{Digits: {Max: 3}}},
+		}, "at least 5 but no more than 3 Digits"},
+		{[]CharRecipe{{Length: 8, Allow: Digits, NoRepeat: true}, {Length: 8, Allow: Digits, MaxSequence: 2}}, "NoRepeat"},
+		{[]CharRecipe{
+			{Length: 2, Allow: Letters, ClassCounts: map[CTFlag]CountRange{Uppers: {Min: 2}}},
+			{Length: 2, Allow: Letters, Require: Lowers},
+		}, "no password of length 2"},
+	}
+	for i, v := range vecs {
+		_, err := IntersectRecipes(v.recipes...)
+		var ie *IntersectionError
+		if !errors.As(err, &ie) {
+			t.Errorf("%d: expected an IntersectionError, got %v", i, err)
+			continue
+		}
+		if !strings.Contains(ie.Error(), v.reason) {
+			t.Errorf("%d: %q should mention %q", i, ie.Error(), v.reason)
+		}
+	}
+
+	if _, err := IntersectRecipes(); err == nil {
+		t.Error("intersecting nothing should fail")
+	}
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
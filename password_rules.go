@@ -0,0 +1,341 @@
+package spg
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*** Password rules
+
+	Websites can describe what passwords they accept with the passwordrules
+	attribute (https://developer.apple.com/password-rules/), for example
+
+		minlength: 20; required: upper; required: digit; allowed: [-().&@?'#,/"+]; max-consecutive: 2
+
+	Each "required" property is one requirement, met by any character from
+	any of the classes it lists. "allowed" adds to the characters that may be
+	used. The classes are upper, lower, digit, special, ascii-printable,
+	unicode, and custom classes in square brackets.
+
+	Space is part of special and ascii-printable, but we leave it out. A password
+	without spaces still meets the rules, and spaces in passwords cause trouble.
+
+***/
+
+// PasswordRulesLength is the length of passwords from ParsePasswordRules,
+// if minlength and maxlength allow it
+const PasswordRulesLength = 20
+
+// ASCII characters in the password rules classes (other than space)
+const (
+	prSpecial        = "-~!@#$%^&*_+=`|(){}[:;\"'<>,.?]"
+	prASCIIPrintable = ctUpper + ctLower + ctDigits + prSpecial + "/\\"
+)
+
+// prClass is a character class in password rules
+type prClass struct {
+	name  string // upper, lower, ... or "" for a custom class
+	chars string
+}
+
+// ParsePasswordRules turns rules in the passwordrules syntax into a CharRecipe.
+// The rules may have HTML entities (such as &quot;), as they would in an attribute.
+// Properties that we can't meet, such as a class of unicode, give an error.
+func ParsePasswordRules(rules string) (*CharRecipe, error) {
+	rules = html.UnescapeString(rules)
+	r := &CharRecipe{}
+	minLength, maxLength := 0, 0
+	anyAllowed := false
+
+	props, err := splitPasswordRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	for _, prop := range props {
+		name, value := prop[0], prop[1]
+		switch name {
+		case "required", "allowed":
+			classes, err := parsePRClasses(value)
+			if err != nil {
+				return nil, err
+			}
+			if len(classes) == 0 {
+				continue
+			}
+			anyAllowed = true
+			if name == "allowed" {
+				for _, cl := range classes {
+					if f, ok := prFlags[cl.name]; ok {
+						r.Allow |= f
+					} else {
+						r.AllowChars += cl.chars
+					}
+				}
+				continue
+			}
+			if f, ok := prFlags[classes[0].name]; ok && len(classes) == 1 {
+				r.Require |= f
+				continue
+			}
+			chars := ""
+			for _, cl := range classes {
+				chars += cl.chars
+			}
+			r.RequireSets = append(r.RequireSets, chars)
+
+		case "minlength", "maxlength", "max-consecutive":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 || (n == 0 && name == "max-consecutive") {
+				return nil, fmt.Errorf("%w: %q is not a valid value for %s", ErrSyntax, value, name)
+			}
+			switch name {
+			case "minlength":
+				if n > minLength {
+					minLength = n
+				}
+			case "maxlength":
+				if maxLength == 0 || n < maxLength {
+					maxLength = n
+				}
+			default:
+				if r.MaxConsecutive == 0 || n < r.MaxConsecutive {
+					r.MaxConsecutive = n
+				}
+			}
+
+		default:
+			return nil, fmt.Errorf("%w: password rule %q", ErrUnsupported, name)
+		}
+	}
+
+	if !anyAllowed {
+		r.AllowChars = prASCIIPrintable
+	}
+
+	r.Length = PasswordRulesLength
+	if maxLength > 0 && r.Length > maxLength {
+		r.Length = maxLength
+	}
+	if r.Length < minLength {
+		r.Length = minLength
+	}
+	if maxLength > 0 && minLength > maxLength {
+		return nil, fmt.Errorf("%w: minlength (%d) is more than maxlength (%d)", ErrBadLength, minLength, maxLength)
+	}
+	return r, nil
+}
+
+// prFlags are the password rule classes that are also character types
+var prFlags = map[string]CTFlag{
+	"upper": Uppers,
+	"lower": Lowers,
+	"digit": Digits,
+}
+
+// prNamed are the characters of the named password rule classes
+var prNamed = map[string]string{
+	"upper":           ctUpper,
+	"lower":           ctLower,
+	"digit":           ctDigits,
+	"special":         prSpecial,
+	"ascii-printable": prASCIIPrintable,
+}
+
+// splitPasswordRules breaks rules into (lowercased) names and values of properties
+func splitPasswordRules(rules string) ([][2]string, error) {
+	var props [][2]string
+	var cur strings.Builder
+	flush := func() error {
+		s := strings.TrimSpace(cur.String())
+		cur.Reset()
+		if s == "" {
+			return nil
+		}
+		i := strings.Index(s, ":")
+		if i < 0 {
+			return fmt.Errorf("%w: password rule %q has no value", ErrSyntax, s)
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:i]))
+		props = append(props, [2]string{name, strings.TrimSpace(s[i+1:])})
+		return nil
+	}
+	for i := 0; i < len(rules); i++ {
+		switch rules[i] {
+		case '[':
+			end := classEnd(rules[i:])
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated custom character class", ErrSyntax)
+			}
+			cur.WriteString(rules[i : i+end+1])
+			i += end
+			continue
+		case ';':
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		cur.WriteByte(rules[i])
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return props, nil
+}
+
+// classEnd returns the position of the ] that ends the custom class at the
+// start of s, or -1 if there isn't one. A ] right at the start of the class
+// (or after a leading -) is part of it, as long as more of the class follows.
+func classEnd(s string) int {
+	i := 1
+	if strings.HasPrefix(s[i:], "-") {
+		i++
+	}
+	if strings.HasPrefix(s[i:], "]") && i+1 < len(s) && !strings.ContainsAny(s[i+1:i+2], ";, \t") {
+		i++
+	}
+	j := strings.Index(s[i:], "]")
+	if j < 0 {
+		return -1
+	}
+	return i + j
+}
+
+// parsePRClasses parses the comma separated classes of a required or allowed property
+func parsePRClasses(value string) ([]prClass, error) {
+	var classes []prClass
+	for len(value) > 0 {
+		value = strings.TrimLeft(value, " \t,")
+		if value == "" {
+			break
+		}
+		if value[0] == '[' {
+			end := classEnd(value)
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated custom character class in %q", ErrSyntax, value)
+			}
+			chars := value[1:end]
+			for _, c := range chars {
+				if c <= ' ' || c > '~' {
+					return nil, fmt.Errorf("%w: character %q in custom character class", ErrUnsupported, c)
+				}
+			}
+			classes = append(classes, prClass{chars: chars})
+			value = value[end+1:]
+			continue
+		}
+		end := strings.IndexAny(value, ", \t")
+		if end < 0 {
+			end = len(value)
+		}
+		name := strings.ToLower(value[:end])
+		value = value[end:]
+		chars, ok := prNamed[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: character class %q", ErrUnsupported, name)
+		}
+		classes = append(classes, prClass{name: name, chars: chars})
+	}
+	return classes, nil
+}
+
+// prClassesFor describes chars (which must all be ASCII) as
+// password rule classes, using names wherever all of a class is there
+func prClassesFor(chars []string) string {
+	have := make(map[string]bool)
+	for _, c := range chars {
+		have[c] = true
+	}
+	var out []string
+	for _, name := range []string{"upper", "lower", "digit"} {
+		all := true
+		for _, c := range prNamed[name] {
+			all = all && have[string(c)]
+		}
+		if all {
+			out = append(out, name)
+			for _, c := range prNamed[name] {
+				delete(have, string(c))
+			}
+		}
+	}
+	if len(have) == 0 {
+		return strings.Join(out, ", ")
+	}
+
+	// In a custom class, - must come first and ] straight after
+	var rest []string
+	for c := range have {
+		rest = append(rest, c)
+	}
+	sort.Slice(rest, func(i, j int) bool {
+		rank := func(s string) int {
+			switch s {
+			case "-":
+				return 0
+			case "]":
+				return 1
+			}
+			return 2
+		}
+		if rank(rest[i]) != rank(rest[j]) {
+			return rank(rest[i]) < rank(rest[j])
+		}
+		return rest[i] < rest[j]
+	})
+	return strings.Join(append(out, "["+strings.Join(rest, "")+"]"), ", ")
+}
+
+// PasswordRules describes r in the passwordrules syntax. Only recipes that can be
+// described exactly are supported. Counts other than "at least one", rules for
+// positions, NoRepeat, MaxSequence, and characters other than printable ASCII
+// (not counting space) all give an error.
+func (r CharRecipe) PasswordRules() (string, error) {
+	if r.Length < 1 {
+		return "", &RecipeError{Problem: ProblemBadLength, Length: r.Length}
+	}
+	if len(r.Positions) > 0 || r.NoRepeat || r.MaxSequence > 0 {
+		return "", fmt.Errorf("%w: positions, NoRepeat, and MaxSequence can't be expressed as password rules", ErrUnsupported)
+	}
+	abc := r.buildCharacterList()
+	if len(abc) == 0 {
+		return "", &RecipeError{Problem: ProblemEmptyAlphabet}
+	}
+	for _, c := range abc {
+		if !strings.Contains(prASCIIPrintable, c) {
+			return "", fmt.Errorf("%w: %q can't be expressed in password rules", ErrUnsupported, c)
+		}
+	}
+
+	parts := []string{
+		fmt.Sprintf("minlength: %d", r.Length),
+		fmt.Sprintf("maxlength: %d", r.Length),
+	}
+	for _, rs := range r.requiredSets {
+		if rs.min > 1 || rs.max > 0 {
+			return "", fmt.Errorf("%w: bounds on %s can't be expressed as password rules", ErrUnsupported, rs.Name)
+		}
+		if rs.min == 0 {
+			continue
+		}
+		if rs.size() == 0 {
+			return "", &RecipeError{Problem: ProblemEmptyRequiredSet, Set: rs.Name}
+		}
+		chars := strings.Split(stringFromSet(rs.s), "")
+		sort.Strings(chars)
+		parts = append(parts, "required: "+prClassesFor(chars))
+	}
+	parts = append(parts, "allowed: "+prClassesFor(abc))
+	if r.MaxConsecutive > 0 {
+		parts = append(parts, fmt.Sprintf("max-consecutive: %d", r.MaxConsecutive))
+	}
+	return strings.Join(parts, "; "), nil
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
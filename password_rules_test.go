@@ -0,0 +1,132 @@
+package spg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePasswordRules(t *testing.T) {
+	r, err := ParsePasswordRules(`minlength: 20; required: upper; required: digit; allowed: [-().&@?'#,/&quot;+]; max-consecutive: 2`)
+	if err != nil {
+		t.Fatalf("couldn't parse rules: %v", err)
+	}
+	if r.Length != 20 || r.Require != Uppers|Digits || r.MaxConsecutive != 2 {
+		t.Errorf("wrong recipe: %+v", r)
+	}
+	if got, want := r.Alphabet(), `"#&'()+,-./0123456789?@ABCDEFGHIJKLMNOPQRSTUVWXYZ`; got != want {
+		t.Errorf("alphabet should be %q, was %q", want, got)
+	}
+	for i := 0; i < 10; i++ {
+		p, err := r.Generate()
+		if err != nil {
+			t.Fatalf("failed to generate: %v", err)
+		}
+		if !r.accepts(p.String()) {
+			t.Errorf("%q doesn't meet the rules", p)
+		}
+	}
+
+	vecs := []struct {
+		rules    string
+		length   int
+		alphabet string
+		reqSets  int // custom required sets
+	}{
+		{"", 20, prASCIIPrintable, 0},
+		{"maxlength: 12", 12, prASCIIPrintable, 0},
+		{"minlength: 8; maxlength: 16; allowed: lower", 16, ctLower, 0},
+		{"minlength: 32; allowed: digit", 32, ctDigits, 0},
+		{"required: lower, upper; required: [-]]", 20, ctLower + ctUpper + "-]", 2},
+		{"Required: SPECIAL; allowed: [abc], digit", 20, prSpecial + "abc" + ctDigits, 1},
+		{"required: [;]; allowed: lower", 20, ctLower + ";", 1},
+		{"required: [-]; allowed: lower", 20, ctLower + "-", 1},
+	}
+	for _, v := range vecs {
+		r, err := ParsePasswordRules(v.rules)
+		if err != nil {
+			t.Errorf("couldn't parse %q: %v", v.rules, err)
+			continue
+		}
+		if r.Length != v.length {
+			t.Errorf("%q: length should be %d, was %d", v.rules, v.length, r.Length)
+		}
+		if got, want := r.Alphabet(), sortedString(v.alphabet); got != want {
+			t.Errorf("%q: alphabet should be %q, was %q", v.rules, want, got)
+		}
+		if len(r.RequireSets) != v.reqSets {
+			t.Errorf("%q: should have %d requirements, has %d", v.rules, v.reqSets, len(r.RequireSets))
+		}
+	}
+}
+
+func sortedString(s string) string {
+	r := CharRecipe{AllowChars: s}
+	return r.Alphabet()
+}
+
+func TestParsePasswordRulesErrors(t *testing.T) {
+	bad := []string{
+		"required: unicode",
+		"allowed: emoji",
+		"max-length: 20",
+		"minlength: twenty",
+		"minlength: 20; maxlength: 10",
+		"max-consecutive: 0",
+		"allowed: [abc",
+		"allowed: [aé]",
+		"required",
+	}
+	for _, rules := range bad {
+		if _, err := ParsePasswordRules(rules); err == nil {
+			t.Errorf("%q should not parse", rules)
+		}
+	}
+}
+
+func TestPasswordRulesRoundTrip(t *testing.T) {
+	recipes := []CharRecipe{
+		*NewCharRecipe(16),
+		{Length: 12, Allow: Letters | Digits, Require: Uppers | Digits, MaxConsecutive: 3},
+		{Length: 10, AllowChars: "-]abc", RequireSets: []string{"-]", "ab"}},
+		{Length: 8, Allow: All, Exclude: Ambiguous, Require: Symbols},
+	}
+	for _, r := range recipes {
+		rules, err := r.PasswordRules()
+		if err != nil {
+			t.Fatalf("couldn't describe %+v: %v", r, err)
+		}
+		back, err := ParsePasswordRules(rules)
+		if err != nil {
+			t.Fatalf("couldn't parse %q: %v", rules, err)
+		}
+		if back.Length != r.Length || back.Alphabet() != r.Alphabet() ||
+			back.MaxConsecutive != r.MaxConsecutive || back.Entropy() != r.Entropy() {
+			t.Errorf("%q doesn't describe the same recipe", rules)
+		}
+	}
+
+	rules, _ := (CharRecipe{Length: 4, Allow: Digits}).PasswordRules()
+	if want := "minlength: 4; maxlength: 4; allowed: digit"; rules != want {
+		t.Errorf("rules should be %q, not %q", want, rules)
+	}
+
+	bad := []CharRecipe{
+		{Length: 8, Allow: Digits, NoRepeat: true},
+		{Length: 8, Allow: Digits, Positions: []PositionRule{{Position: 0, AllowChars: "1"}}},
+		{Length: 8, Allow: Digits, ClassCounts: map[CTFlag]CountRange{Digits: {Min: 2}}},
+		{Length: 8, AllowChars: "é"},
+	}
+	for _, r := range bad {
+		if rules, err := r.PasswordRules(); err == nil {
+			t.Errorf("%+v should not be described as %q", r, rules)
+		}
+	}
+	if !strings.Contains(prASCIIPrintable, "/") {
+		t.Error("ascii-printable should include /")
+	}
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
@@ -0,0 +1,204 @@
+package spg
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+/*** Pattern passwords
+
+	Some formats are fixed, such as "Cvccvc-99-Cvccvc" or "xxxxxx-xxxxxx-xxxxxx".
+	A pattern is a template in which each placeholder character stands
+	for one character drawn from a class, and everything else is kept as is.
+
+	Each placeholder is picked independently and uniformly from its class,
+	and different picks always give different passwords, so generation is
+	uniform and the entropy is just the sum of log2 of the sizes of the classes.
+
+***/
+
+// Consonants and vowels for pronounceable-ish patterns
+const (
+	ptConsonants = "bcdfghjklmnpqrstvwxyz"
+	ptVowels     = "aeiou"
+)
+
+// patternPlaceholders are the placeholders that patterns understand,
+// and the characters each of them may stand for
+var patternPlaceholders = map[rune]string{
+	'A': ctUpper,
+	'a': ctLower,
+	'9': ctDigits,
+	'#': ctSymbols,
+	'C': strings.ToUpper(ptConsonants),
+	'c': ptConsonants,
+	'V': strings.ToUpper(ptVowels),
+	'v': ptVowels,
+	'x': ctLower + ctDigits,
+	'X': ctUpper + ctLower + ctDigits,
+	'*': ctUpper + ctLower + ctDigits + ctSymbols,
+}
+
+// PatternPlaceholders returns the placeholders that every pattern understands,
+// and the characters each of them may stand for. The map is a copy, so
+// changing it has no effect on patterns. Use PatternRecipe.Placeholders for that.
+func PatternPlaceholders() map[rune]string {
+	ph := make(map[rune]string, len(patternPlaceholders))
+	for p, chars := range patternPlaceholders {
+		ph[p] = chars
+	}
+	return ph
+}
+
+// PatternRecipe is a recipe for passwords that follow a template, such as "Cvccvc-99-Cvccvc".
+//
+// Each placeholder character in Pattern is replaced by a character from its class.
+// Anything else, or any character after a backslash, appears as it is.
+// The placeholders are those from PatternPlaceholders along with any in Placeholders,
+// which take precedence.
+//
+//	A uppercase letter    a lowercase letter    9 digit    # symbol
+//	C uppercase consonant c lowercase consonant
+//	V uppercase vowel     v lowercase vowel
+//	x lowercase letter or digit    X letter or digit    * any of those or a symbol
+//
+// Exclude and ExcludeChars remove characters from every class.
+type PatternRecipe struct {
+	Pattern      string          // The template
+	Placeholders map[rune]string // Extra placeholders, each standing for one of the characters in its string
+
+	Exclude      CTFlag // Types that must not appear
+	ExcludeChars string // Specific characters that must not appear
+
+	Source RandomSource // Where random bytes come from. If nil, crypto/rand is used
+}
+
+// NewPatternRecipe creates a PatternRecipe for pattern, excluding ambiguous characters
+func NewPatternRecipe(pattern string) *PatternRecipe {
+	return &PatternRecipe{Pattern: pattern, Exclude: Ambiguous}
+}
+
+// patternPart is either a placeholder, with the characters it may be replaced by,
+// or a literal
+type patternPart struct {
+	class   charList // nil for a literal
+	literal string
+}
+
+// parse breaks r.Pattern into placeholders and literals
+func (r PatternRecipe) parse() ([]patternPart, error) {
+	excluded := setFromString(charsOfFlags(r.Exclude) + r.ExcludeChars)
+	classes := make(map[rune]charList)
+	classFor := func(p rune) (charList, error) {
+		if cl, ok := classes[p]; ok {
+			return cl, nil
+		}
+		chars, ok := r.Placeholders[p]
+		if !ok {
+			chars = patternPlaceholders[p]
+		}
+		s := setFromString(chars).Difference(excluded)
+		s.Remove("")
+		if s.Cardinality() == 0 {
+			return nil, fmt.Errorf("%w: placeholder %q has no characters to pick from", ErrEmptyAlphabet, p)
+		}
+		cl := charList(strings.Split(stringFromSet(s), ""))
+		sort.Strings(cl)
+		classes[p] = cl
+		return cl, nil
+	}
+
+	var parts []patternPart
+	escaped := false
+	for _, c := range r.Pattern {
+		_, custom := r.Placeholders[c]
+		_, builtin := patternPlaceholders[c]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+			continue
+		case custom || builtin:
+			cl, err := classFor(c)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, patternPart{class: cl})
+			continue
+		}
+		parts = append(parts, patternPart{literal: string(c)})
+	}
+	if escaped {
+		return nil, fmt.Errorf("%w: pattern ends with a backslash", ErrSyntax)
+	}
+	return parts, nil
+}
+
+// Generate a password following the pattern of the recipe
+func (r PatternRecipe) Generate() (*Password, error) {
+	return r.GenerateFrom(r.Source)
+}
+
+// GenerateFrom is like Generate, but draws its randomness from rs
+// instead of from r.Source. A nil rs means crypto/rand.
+//
+// Runs of placeholders become atoms of the password,
+// and runs of literals become separators.
+func (r PatternRecipe) GenerateFrom(rs RandomSource) (*Password, error) {
+	parts, err := r.parse()
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("%w: empty pattern", ErrSyntax)
+	}
+
+	p := &Password{Entropy: r.Entropy()}
+	value := ""
+	tt := AtomType
+	for i, part := range parts {
+		ptt := SeparatorType
+		c := part.literal
+		if part.class != nil {
+			ptt = AtomType
+			j, err := randomUint32n(rs, uint32(len(part.class)))
+			if err != nil {
+				return nil, err
+			}
+			c = part.class[j]
+		}
+		if i > 0 && ptt != tt {
+			p.tokens = append(p.tokens, Token{value, tt})
+			value = ""
+		}
+		value += c
+		tt = ptt
+	}
+	p.tokens = append(p.tokens, Token{value, tt})
+	return p, nil
+}
+
+// Entropy returns the entropy of passwords from r, the sum of log2 of
+// the sizes of the classes of the placeholders. It is NaN if the pattern
+// can't be used.
+func (r PatternRecipe) Entropy() float32 {
+	parts, err := r.parse()
+	if err != nil {
+		return float32(math.NaN())
+	}
+	ent := 0.0
+	for _, part := range parts {
+		if part.class != nil {
+			ent += math.Log2(float64(len(part.class)))
+		}
+	}
+	return float32(ent)
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
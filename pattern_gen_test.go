@@ -0,0 +1,98 @@
+package spg
+
+import (
+	"math"
+	"regexp"
+	"testing"
+)
+
+func TestPatternEntropy(t *testing.T) {
+	vecs := []struct {
+		pattern string
+		ent     float64
+	}{
+		{"9999", 4 * math.Log2(10)},
+		{"Cvccvc-99-Cvccvc", 2*math.Log2(21) + 6*math.Log2(21) + 4*math.Log2(5) + 2*math.Log2(10)},
+		{"xxxxxx-xxxxxx-xxxxxx", 18 * math.Log2(36)},
+		{`\9\\`, 0},
+	}
+	for _, v := range vecs {
+		r := PatternRecipe{Pattern: v.pattern}
+		if got := r.Entropy(); cmpFloat32(got, float32(v.ent), 100000) != 0 {
+			t.Errorf("%q: entropy should be %f, was %f", v.pattern, v.ent, got)
+		}
+	}
+
+	r := NewPatternRecipe("A9")
+	want := math.Log2(23) + math.Log2(7) // no O, I, S, 0, 1, 5
+	if got := r.Entropy(); cmpFloat32(got, float32(want), 100000) != 0 {
+		t.Errorf("entropy without ambiguous characters should be %f, was %f", want, got)
+	}
+}
+
+func TestPatternGenerate(t *testing.T) {
+	r := PatternRecipe{Pattern: `Cvccvc-99-Cvccvc\x`, Source: newDetSource("pattern")}
+	re := regexp.MustCompile(`^[B-DF-HJ-NP-TV-Z][aeiou][b-df-hj-np-tv-z]{2}[aeiou][b-df-hj-np-tv-z]-[0-9]{2}-[B-DF-HJ-NP-TV-Z][aeiou][b-df-hj-np-tv-z]{2}[aeiou][b-df-hj-np-tv-z]x$`)
+	for i := 0; i < 20; i++ {
+		p, err := r.Generate()
+		if err != nil {
+			t.Fatalf("failed to generate: %v", err)
+		}
+		if !re.MatchString(p.String()) {
+			t.Errorf("%q doesn't follow the pattern", p)
+		}
+		types := []TokenType{AtomType, SeparatorType, AtomType, SeparatorType, AtomType, SeparatorType}
+		toks := p.Tokens()
+		if len(toks) != len(types) {
+			t.Fatalf("%q should have %d tokens, not %d", p, len(types), len(toks))
+		}
+		for j, tok := range toks {
+			if tok.Type() != types[j] {
+				t.Errorf("token %d (%q) of %q has the wrong type", j, tok.Value(), p)
+			}
+		}
+	}
+
+	// Custom placeholders take precedence, and exclusions apply to them
+	r = PatternRecipe{
+		Pattern:      "ZZZ-a",
+		Placeholders: map[rune]string{'Z': "üöß", 'a': "ab"},
+		ExcludeChars: "ßb",
+	}
+	p, err := r.Generate()
+	if err != nil {
+		t.Fatalf("failed to generate: %v", err)
+	}
+	if !regexp.MustCompile(`^[üö]{3}-a$`).MatchString(p.String()) {
+		t.Errorf("%q doesn't follow the pattern", p)
+	}
+}
+
+func TestPatternPlaceholders(t *testing.T) {
+	ph := PatternPlaceholders()
+	if ph['9'] != ctDigits {
+		t.Errorf("9 should stand for a digit, not one of %q", ph['9'])
+	}
+	ph['9'] = "x"
+	if PatternPlaceholders()['9'] != ctDigits {
+		t.Error("changing the placeholders returned changed those of every pattern")
+	}
+}
+
+func TestPatternErrors(t *testing.T) {
+	recipes := []PatternRecipe{
+		{Pattern: ""},
+		{Pattern: `99\`},
+		{Pattern: "9", Exclude: Digits},
+	}
+	for _, r := range recipes {
+		if _, err := r.Generate(); err == nil {
+			t.Errorf("%q should not generate", r.Pattern)
+		}
+	}
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
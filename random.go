@@ -0,0 +1,85 @@
+package spg
+
+import (
+	rand "crypto/rand"
+	"io"
+	"sync"
+)
+
+// RandomSource is where the generators get their random bytes from.
+// Anything that satisfies io.Reader will do. This allows callers to plug in
+// an HSM-backed reader, a DRBG, or, for reproducible tests only, a deterministic
+// stream. The package takes care of turning the bytes into unbiased choices,
+// but it is up to the source to provide bytes that are uniform and unpredictable.
+//
+// A nil RandomSource means crypto/rand.
+type RandomSource interface {
+	Read(p []byte) (n int, err error)
+}
+
+// sourceOrDefault returns rs, or the system's cryptographic source if rs is nil
+func sourceOrDefault(rs RandomSource) RandomSource {
+	if rs == nil {
+		return rand.Reader
+	}
+	return rs
+}
+
+// DefaultBufferSize is the size of the buffer of a buffered source, if not given
+const DefaultBufferSize = 4096
+
+// bufferedSource is a RandomSource that reads from another in large blocks
+type bufferedSource struct {
+	mu  sync.Mutex
+	rs  RandomSource
+	buf []byte
+	pos int // buf[pos:] has not been handed out yet
+}
+
+// NewBufferedSource returns a RandomSource that reads size bytes at a time
+// from rs (crypto/rand if rs is nil) and hands them out as they are asked
+// for. Generating a password takes a few bytes for each character or word,
+// so this saves a read of the underlying source for each of them when
+// generating many passwords. It helps most when reading rs is slow or costly.
+// A size less than 1 means DefaultBufferSize.
+//
+// The bytes are only as good as those of rs. Each is handed out once,
+// so choices are just as uniform as they would be without the buffer.
+// Bytes waiting to be used stay in memory until they are, so don't keep
+// a buffered source around for longer than it is needed.
+// It is safe for concurrent use.
+func NewBufferedSource(rs RandomSource, size int) RandomSource {
+	if size < 1 {
+		size = DefaultBufferSize
+	}
+	buf := make([]byte, size)
+	return &bufferedSource{rs: sourceOrDefault(rs), buf: buf, pos: size}
+}
+
+// Read fills p from the buffer, refilling it from the underlying source as needed
+func (b *bufferedSource) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for n < len(p) {
+		if b.pos == len(b.buf) {
+			if _, err := io.ReadFull(b.rs, b.buf); err != nil {
+				return n, err
+			}
+			b.pos = 0
+		}
+		c := copy(p[n:], b.buf[b.pos:])
+		// Used bytes are cleared, so they don't stay around in memory
+		for i := b.pos; i < b.pos+c; i++ {
+			b.buf[i] = 0
+		}
+		b.pos += c
+		n += c
+	}
+	return n, nil
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
@@ -0,0 +1,238 @@
+package spg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+)
+
+// detSource is a deterministic RandomSource for reproducible tests.
+// It is a SHA-256 counter stream, so it is well distributed but
+// entirely predictable. Never use anything like it outside of tests.
+type detSource struct {
+	seed    string
+	counter uint64
+	buf     []byte
+}
+
+func newDetSource(seed string) *detSource {
+	return &detSource{seed: seed}
+}
+
+func (d *detSource) Read(p []byte) (int, error) {
+	for i := range p {
+		if len(d.buf) == 0 {
+			ctr := make([]byte, 8)
+			binary.BigEndian.PutUint64(ctr, d.counter)
+			d.counter++
+			block := sha256.Sum256(append([]byte(d.seed), ctr...))
+			d.buf = block[:]
+		}
+		p[i] = d.buf[0]
+		d.buf = d.buf[1:]
+	}
+	return len(p), nil
+}
+
+// failingSource is a RandomSource that never works
+type failingSource struct{}
+
+func (failingSource) Read(p []byte) (int, error) {
+	return 0, errors.New("no randomness for you")
+}
+
+func TestDeterministicSource(t *testing.T) {
+	wl, err := NewWordList([]string{"one", "two", "three", "four", "five"})
+	if err != nil {
+		t.Fatalf("failed to create word list: %v", err)
+	}
+	wlr := NewWLRecipe(6, wl)
+	wlr.SeparatorChar = "-"
+	wlr.Capitalize = CSRandom
+
+	recipes := []Generator{
+		&CharRecipe{Length: 20, Allow: Letters | Digits, Source: newDetSource("chars")},
+		wlr,
+	}
+	wlr.Source = newDetSource("words")
+
+	for _, g := range recipes {
+		a, err := g.Generate()
+		if err != nil {
+			t.Fatalf("failed to generate: %v", err)
+		}
+
+		// A fresh source with the same seed should give the same password
+		var b *Password
+		switch r := g.(type) {
+		case *CharRecipe:
+			b, err = r.GenerateFrom(newDetSource("chars"))
+		case *WLRecipe:
+			b, err = r.GenerateFrom(newDetSource("words"))
+		}
+		if err != nil {
+			t.Fatalf("failed to generate: %v", err)
+		}
+		if a.String() != b.String() {
+			t.Errorf("same source gave different passwords: %q and %q", a, b)
+		}
+	}
+
+	// and a different seed should (almost certainly) not
+	r := CharRecipe{Length: 20, Allow: Letters | Digits}
+	a, _ := r.GenerateFrom(newDetSource("one seed"))
+	b, _ := r.GenerateFrom(newDetSource("another seed"))
+	if a.String() == b.String() {
+		t.Errorf("different sources gave the same password: %q", a)
+	}
+}
+
+func TestNilSourceIsCryptoRand(t *testing.T) {
+	r := CharRecipe{Length: 4, Allow: Digits}
+	p, err := r.GenerateFrom(nil)
+	if err != nil {
+		t.Fatalf("failed to generate from default source: %v", err)
+	}
+	if len(p.String()) != 4 {
+		t.Errorf("%q should have been four digits", p)
+	}
+}
+
+func TestFailingSource(t *testing.T) {
+	wl, err := NewWordList([]string{"one", "two", "three", "four", "five"})
+	if err != nil {
+		t.Fatalf("failed to create word list: %v", err)
+	}
+	wlr := NewWLRecipe(4, wl)
+	wlr.Capitalize = CSRandom
+	sepr := NewWLRecipe(4, wl)
+	sepr.SeparatorFunc = NewSFFunction(CharRecipe{Length: 2, Allow: Digits, Source: failingSource{}})
+
+	generators := map[string]Generator{
+		"characters": &CharRecipe{Length: 20, Allow: Letters | Digits, Source: failingSource{}},
+		"exact": &CharRecipe{Length: 3, Allow: Letters, RequireSets: []string{"a", "b", "c"},
+			Source: failingSource{}},
+		"words":     &WLRecipe{Length: 4, list: wl, Source: failingSource{}},
+		"capitals":  &WLRecipe{Length: 4, list: wl, Capitalize: CSOne, Source: failingSource{}},
+		"separator": sepr,
+		"regex":     &RegexRecipe{Pattern: "[a-z]{8}", MinLength: 8, MaxLength: 8, Source: failingSource{}},
+		"pattern":   &PatternRecipe{Pattern: "Cvccvc-99", Source: failingSource{}},
+		"composite": &CompositeRecipe{Parts: []Generator{wlr}, Source: failingSource{}},
+	}
+	for name, g := range generators {
+		if _, err := g.Generate(); !errors.Is(err, ErrRandomSource) {
+			t.Errorf("%s: expected ErrRandomSource, got %v", name, err)
+		}
+	}
+
+	// Entropy of a preset separator doesn't need randomness
+	sepr.SeparatorFunc = nil
+	sepr.Separator = SepDigits2
+	sepr.Source = failingSource{}
+	if want := float32(entropySimple(4, 5) + 3*entropySimple(2, 10)); cmpFloat32(sepr.Entropy(), want, 100000) != 0 {
+		t.Errorf("entropy with a failing source should be %f, not %f", want, sepr.Entropy())
+	}
+	if !math.IsNaN(float64(WLRecipe{Length: 3}.Entropy())) {
+		t.Error("entropy without a word list should be NaN")
+	}
+}
+
+// countingSource counts the reads made of the source it wraps
+type countingSource struct {
+	rs    RandomSource
+	reads int
+}
+
+func (c *countingSource) Read(p []byte) (int, error) {
+	c.reads++
+	return c.rs.Read(p)
+}
+
+func TestBufferedSource(t *testing.T) {
+	// Buffering doesn't change what is read, only how
+	plain := newDetSource("buffered")
+	under := &countingSource{rs: newDetSource("buffered")}
+	buffered := NewBufferedSource(under, 64)
+	for _, n := range []int{1, 4, 63, 64, 200, 3} {
+		want := make([]byte, n)
+		got := make([]byte, n)
+		if _, err := plain.Read(want); err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+		if k, err := buffered.Read(got); err != nil || k != n {
+			t.Fatalf("read %d of %d bytes: %v", k, n, err)
+		}
+		if !bytes.Equal(want, got) {
+			t.Errorf("buffered read of %d bytes differs", n)
+		}
+	}
+	if under.reads != 6 { // ceil(335 / 64)
+		t.Errorf("expected 6 reads of the underlying source, got %d", under.reads)
+	}
+
+	r := CharRecipe{Length: 20, Allow: Letters | Digits}
+	under = &countingSource{rs: newDetSource("chars")}
+	r.Source = NewBufferedSource(under, 0)
+	for i := 0; i < 10; i++ {
+		if _, err := r.Generate(); err != nil {
+			t.Fatalf("failed to generate: %v", err)
+		}
+	}
+	if under.reads != 1 {
+		t.Errorf("ten passwords should fit in one buffer, took %d reads", under.reads)
+	}
+
+	if _, err := NewBufferedSource(failingSource{}, 0).Read(make([]byte, 4)); err == nil {
+		t.Error("a failing source should fail when buffered")
+	}
+}
+
+func benchmarkGenerate(b *testing.B, g sourcedGenerator) {
+	sources := []struct {
+		name string
+		rs   RandomSource
+	}{
+		{"crypto", nil},
+		{"buffered", NewBufferedSource(nil, 0)},
+	}
+	for _, s := range sources {
+		b.Run(s.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := g.GenerateFrom(s.rs); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCharRecipeGenerate(b *testing.B) {
+	benchmarkGenerate(b, NewCharRecipe(20))
+}
+
+func BenchmarkCompiledCharRecipeGenerate(b *testing.B) {
+	c, err := NewCharRecipe(20).Compile()
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkGenerate(b, c)
+}
+
+func BenchmarkWLRecipeGenerate(b *testing.B) {
+	wl, err := BuiltinWordList("words")
+	if err != nil {
+		b.Fatal(err)
+	}
+	r := NewWLRecipe(6, wl)
+	r.SeparatorChar = "-"
+	r.Capitalize = CSRandom
+	benchmarkGenerate(b, r)
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
@@ -0,0 +1,396 @@
+package spg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*** Saving and loading recipes
+
+	Recipes can be written as JSON, so that they can be kept in version
+	control next to whatever uses them. Every recipe document has a version,
+	which is RecipeVersion for anything we write, and a type. For example
+
+		{"version": 1, "type": "characters", "length": 20,
+		 "allow": ["uppercase", "lowercase", "digits"], "exclude": ["ambiguous"]}
+
+		{"version": 1, "type": "words", "length": 4, "list": "words",
+		 "separator": "-", "capitalize": "one"}
+
+	A separator is either a string, or a character recipe for separators
+	such as {"length": 2, "allow": ["digits"]}.
+
+	Word lists are referred to by name. The built-in ones are "words"
+	and "syllables". Others can be found by passing a resolver to ParseRecipe.
+
+***/
+
+// RecipeVersion is the version of the JSON format for recipes
+const RecipeVersion = 1
+
+// Recipe types in JSON
+const (
+	recipeTypeChars = "characters"
+	recipeTypeWords = "words"
+)
+
+// ctFlagNames are the JSON names of single character types
+var ctFlagNames = map[CTFlag]string{
+	Uppers:    "uppercase",
+	Lowers:    "lowercase",
+	Digits:    "digits",
+	Symbols:   "symbols",
+	Ambiguous: "ambiguous",
+}
+
+// flagNames returns the JSON names of the character types in f
+func flagNames(f CTFlag) []string {
+	var names []string
+	for _, flag := range ctFlagOrder {
+		if f&flag != 0 {
+			names = append(names, ctFlagNames[flag])
+		}
+	}
+	return names
+}
+
+// flagsFromNames is the inverse of flagNames
+func flagsFromNames(names []string) (CTFlag, error) {
+	f := None
+	for _, name := range names {
+		found := false
+		for flag, n := range ctFlagNames {
+			if n == name {
+				f |= flag
+				found = true
+			}
+		}
+		if !found {
+			return None, fmt.Errorf("%w: unknown character type %q", ErrInvalidRecipe, name)
+		}
+	}
+	return f, nil
+}
+
+type recipeHeader struct {
+	Version int    `json:"version"`
+	Type    string `json:"type"`
+}
+
+// check makes sure h is a header for a recipe of type t that we can read
+func (h recipeHeader) check(t string) error {
+	if h.Version != RecipeVersion {
+		return fmt.Errorf("%w: recipe version %d", ErrUnsupported, h.Version)
+	}
+	if h.Type != t {
+		return fmt.Errorf("%w: recipe is of type %q, not %q", ErrInvalidRecipe, h.Type, t)
+	}
+	return nil
+}
+
+// jsonError wraps an error from encoding/json in the package error for it.
+// JSON that can't be read at all is ErrSyntax, and JSON with a value of the
+// wrong type is ErrInvalidRecipe.
+func jsonError(err error) error {
+	var syntax *json.SyntaxError
+	if errors.As(err, &syntax) {
+		return fmt.Errorf("%w: %v", ErrSyntax, err)
+	}
+	return fmt.Errorf("%w: %v", ErrInvalidRecipe, err)
+}
+
+type countRangeJSON struct {
+	Min int `json:"min,omitempty"`
+	Max int `json:"max,omitempty"`
+}
+
+type positionRuleJSON struct {
+	Position     int      `json:"position"`
+	Allow        []string `json:"allow,omitempty"`
+	AllowChars   string   `json:"allowChars,omitempty"`
+	Exclude      []string `json:"exclude,omitempty"`
+	ExcludeChars string   `json:"excludeChars,omitempty"`
+}
+
+// charRecipeFields is everything in a CharRecipe that is saved
+type charRecipeFields struct {
+	Length         int                       `json:"length"`
+	Allow          []string                  `json:"allow,omitempty"`
+	Require        []string                  `json:"require,omitempty"`
+	Exclude        []string                  `json:"exclude,omitempty"`
+	AllowChars     string                    `json:"allowChars,omitempty"`
+	RequireSets    []string                  `json:"requireSets,omitempty"`
+	ExcludeChars   string                    `json:"excludeChars,omitempty"`
+	ClassCounts    map[string]countRangeJSON `json:"classCounts,omitempty"` // keyed by types joined with "+"
+	SetCounts      []countRangeJSON          `json:"setCounts,omitempty"`
+	Positions      []positionRuleJSON        `json:"positions,omitempty"`
+	NoRepeat       bool                      `json:"noRepeat,omitempty"`
+	MaxConsecutive int                       `json:"maxConsecutive,omitempty"`
+	MaxSequence    int                       `json:"maxSequence,omitempty"`
+}
+
+type charRecipeJSON struct {
+	recipeHeader
+	charRecipeFields
+}
+
+type wlRecipeJSON struct {
+	recipeHeader
+	Length     int             `json:"length"`
+	List       string          `json:"list"`
+	Separator  json.RawMessage `json:"separator,omitempty"` // a string, or the fields of a character recipe
+	Capitalize CapScheme       `json:"capitalize,omitempty"`
+	MinChars   int             `json:"minChars,omitempty"`
+	MaxChars   int             `json:"maxChars,omitempty"`
+}
+
+func (r CharRecipe) fields() charRecipeFields {
+	f := charRecipeFields{
+		Length:         r.Length,
+		Allow:          flagNames(r.Allow),
+		Require:        flagNames(r.Require),
+		Exclude:        flagNames(r.Exclude),
+		AllowChars:     r.AllowChars,
+		RequireSets:    r.RequireSets,
+		ExcludeChars:   r.ExcludeChars,
+		NoRepeat:       r.NoRepeat,
+		MaxConsecutive: r.MaxConsecutive,
+		MaxSequence:    r.MaxSequence,
+	}
+	if len(r.ClassCounts) > 0 {
+		f.ClassCounts = make(map[string]countRangeJSON, len(r.ClassCounts))
+		for _, flags := range sortedFlags(r.ClassCounts) {
+			cr := r.ClassCounts[flags]
+			f.ClassCounts[strings.Join(flagNames(flags), "+")] = countRangeJSON(cr)
+		}
+	}
+	for _, cr := range r.SetCounts {
+		f.SetCounts = append(f.SetCounts, countRangeJSON(cr))
+	}
+	for _, pr := range r.Positions {
+		f.Positions = append(f.Positions, positionRuleJSON{
+			Position:     pr.Position,
+			Allow:        flagNames(pr.Allow),
+			AllowChars:   pr.AllowChars,
+			Exclude:      flagNames(pr.Exclude),
+			ExcludeChars: pr.ExcludeChars,
+		})
+	}
+	return f
+}
+
+func (f charRecipeFields) recipe() (*CharRecipe, error) {
+	r := &CharRecipe{
+		Length:         f.Length,
+		AllowChars:     f.AllowChars,
+		RequireSets:    f.RequireSets,
+		ExcludeChars:   f.ExcludeChars,
+		NoRepeat:       f.NoRepeat,
+		MaxConsecutive: f.MaxConsecutive,
+		MaxSequence:    f.MaxSequence,
+	}
+	var err error
+	if r.Allow, err = flagsFromNames(f.Allow); err != nil {
+		return nil, err
+	}
+	if r.Require, err = flagsFromNames(f.Require); err != nil {
+		return nil, err
+	}
+	if r.Exclude, err = flagsFromNames(f.Exclude); err != nil {
+		return nil, err
+	}
+	if len(f.ClassCounts) > 0 {
+		r.ClassCounts = make(map[CTFlag]CountRange, len(f.ClassCounts))
+		keys := make([]string, 0, len(f.ClassCounts))
+		for k := range f.ClassCounts {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			flags, err := flagsFromNames(strings.Split(k, "+"))
+			if err != nil {
+				return nil, err
+			}
+			r.ClassCounts[flags] = CountRange(f.ClassCounts[k])
+		}
+	}
+	for _, cr := range f.SetCounts {
+		r.SetCounts = append(r.SetCounts, CountRange(cr))
+	}
+	for _, pj := range f.Positions {
+		pr := PositionRule{Position: pj.Position, AllowChars: pj.AllowChars, ExcludeChars: pj.ExcludeChars}
+		if pr.Allow, err = flagsFromNames(pj.Allow); err != nil {
+			return nil, err
+		}
+		if pr.Exclude, err = flagsFromNames(pj.Exclude); err != nil {
+			return nil, err
+		}
+		r.Positions = append(r.Positions, pr)
+	}
+	return r, nil
+}
+
+// MarshalJSON writes r in the JSON format for recipes. Source, MaxTrials
+// and MaxFailRate aren't saved, as they don't change what passwords are made.
+func (r CharRecipe) MarshalJSON() ([]byte, error) {
+	return json.Marshal(charRecipeJSON{
+		recipeHeader:     recipeHeader{Version: RecipeVersion, Type: recipeTypeChars},
+		charRecipeFields: r.fields(),
+	})
+}
+
+// UnmarshalJSON reads r from the JSON format for recipes
+func (r *CharRecipe) UnmarshalJSON(data []byte) error {
+	var j charRecipeJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return jsonError(err)
+	}
+	if err := j.check(recipeTypeChars); err != nil {
+		return err
+	}
+	cr, err := j.charRecipeFields.recipe()
+	if err != nil {
+		return err
+	}
+	*r = *cr
+	return nil
+}
+
+// MarshalJSON writes r in the JSON format for recipes. The word list must have
+// a Name. Recipes with a SeparatorFunc can't be saved, as there is no way to tell
+// what a function does. Source isn't saved.
+func (r WLRecipe) MarshalJSON() ([]byte, error) {
+	if r.list == nil || r.list.Name == "" {
+		return nil, fmt.Errorf("%w: can't save a recipe with a word list that has no name", ErrUnsupported)
+	}
+	if r.Separator == nil && r.SeparatorFunc != nil {
+		return nil, fmt.Errorf("%w: can't save a recipe with a SeparatorFunc", ErrUnsupported)
+	}
+	j := wlRecipeJSON{
+		recipeHeader: recipeHeader{Version: RecipeVersion, Type: recipeTypeWords},
+		Length:       r.Length,
+		List:         r.list.Name,
+		Capitalize:   r.Capitalize,
+		MinChars:     r.MinChars,
+		MaxChars:     r.MaxChars,
+	}
+	if sep, ok := r.literalSeparator(); !ok || sep != "" {
+		data, err := r.separator().MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		j.Separator = data
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON reads r from the JSON format for recipes.
+// It only knows about built-in word lists. Use ParseRecipe for others.
+func (r *WLRecipe) UnmarshalJSON(data []byte) error {
+	wlr, err := parseWLRecipe(data, BuiltinWordList)
+	if err != nil {
+		return err
+	}
+	*r = *wlr
+	return nil
+}
+
+func parseWLRecipe(data []byte, lists func(name string) (*WordList, error)) (*WLRecipe, error) {
+	var j wlRecipeJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, jsonError(err)
+	}
+	if err := j.check(recipeTypeWords); err != nil {
+		return nil, err
+	}
+	wl, err := lists(j.List)
+	if err != nil {
+		return nil, err
+	}
+	r := NewWLRecipe(j.Length, wl)
+	r.MinChars, r.MaxChars = j.MinChars, j.MaxChars
+	if len(j.Separator) > 0 && string(j.Separator) != "null" {
+		if err := json.Unmarshal(j.Separator, &r.SeparatorChar); err != nil {
+			var sepFields charRecipeFields
+			if err := json.Unmarshal(j.Separator, &sepFields); err != nil {
+				return nil, fmt.Errorf("%w: separator must be a string or a character recipe", ErrInvalidRecipe)
+			}
+			sr, err := sepFields.recipe()
+			if err != nil {
+				return nil, err
+			}
+			r.Separator = NewCharSeparator(*sr)
+		}
+	}
+	switch j.Capitalize {
+	case "":
+	case CSNone, CSFirst, CSAll, CSRandom, CSOne:
+		r.Capitalize = j.Capitalize
+	default:
+		return nil, fmt.Errorf("%w: unknown capitalization scheme %q", ErrInvalidRecipe, j.Capitalize)
+	}
+	return r, nil
+}
+
+// ParseRecipe reads a recipe of any type from JSON. Word lists are looked up
+// by name with lists, or with BuiltinWordList if lists is nil.
+func ParseRecipe(data []byte, lists func(name string) (*WordList, error)) (Generator, error) {
+	var h recipeHeader
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, jsonError(err)
+	}
+	if lists == nil {
+		lists = BuiltinWordList
+	}
+	switch h.Type {
+	case recipeTypeChars:
+		r := &CharRecipe{}
+		if err := r.UnmarshalJSON(data); err != nil {
+			return nil, err
+		}
+		return r, nil
+	case recipeTypeWords:
+		return parseWLRecipe(data, lists)
+	}
+	return nil, fmt.Errorf("%w: unknown recipe type %q", ErrInvalidRecipe, h.Type)
+}
+
+var builtinLists = struct {
+	sync.Mutex
+	lists map[string]*WordList
+}{lists: make(map[string]*WordList)}
+
+// BuiltinWordList returns the built-in word list called name, "words" (AgileWords)
+// or "syllables" (AgileSyllables). Lists are only set up once.
+func BuiltinWordList(name string) (*WordList, error) {
+	builtinLists.Lock()
+	defer builtinLists.Unlock()
+	if wl, ok := builtinLists.lists[name]; ok {
+		return wl, nil
+	}
+	var words []string
+	switch name {
+	case "words":
+		words = AgileWords
+	case "syllables":
+		words = AgileSyllables
+	default:
+		return nil, fmt.Errorf("%w: no built-in word list called %q", ErrNoWordList, name)
+	}
+	wl, err := NewWordList(words)
+	if err != nil {
+		return nil, err
+	}
+	wl.Name = name
+	builtinLists.lists[name] = wl
+	return wl, nil
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
@@ -0,0 +1,145 @@
+package spg
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestCharRecipeJSON(t *testing.T) {
+	r := CharRecipe{
+		Length:       16,
+		Allow:        Letters | Digits,
+		Require:      Uppers | Symbols,
+		Exclude:      Ambiguous,
+		AllowChars:   "é",
+		RequireSets:  []string{"abc", "xyz"},
+		ExcludeChars: "q",
+		ClassCounts:  map[CTFlag]CountRange{Digits: {Min: 2, Max: 4}, Letters: {Max: 10}},
+		SetCounts:    []CountRange{{Min: 1, Max: 2}},
+		Positions: []PositionRule{
+			{Position: 0, Allow: Letters},
+			{Position: -1, Exclude: Symbols, ExcludeChars: "x"},
+		},
+		MaxConsecutive: 2,
+		MaxSequence:    3,
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("couldn't marshal: %v", err)
+	}
+	var back CharRecipe
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatalf("couldn't unmarshal %s: %v", data, err)
+	}
+	if !reflect.DeepEqual(r, back) {
+		t.Errorf("round trip through %s gave %+v", data, back)
+	}
+
+	// and what we write is what we say we write
+	var doc map[string]interface{}
+	_ = json.Unmarshal(data, &doc)
+	if doc["version"] != float64(RecipeVersion) || doc["type"] != "characters" {
+		t.Errorf("%s doesn't have the right version and type", data)
+	}
+	if _, ok := doc["classCounts"].(map[string]interface{})["uppercase+lowercase"]; !ok {
+		t.Errorf("%s should have bounds on uppercase+lowercase", data)
+	}
+}
+
+func TestWLRecipeJSON(t *testing.T) {
+	wl, err := BuiltinWordList("syllables")
+	if err != nil {
+		t.Fatalf("no syllables: %v", err)
+	}
+	r := NewWLRecipe(5, wl)
+	r.SeparatorChar = " "
+	r.Capitalize = CSOne
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("couldn't marshal: %v", err)
+	}
+	var back WLRecipe
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatalf("couldn't unmarshal %s: %v", data, err)
+	}
+	if !reflect.DeepEqual(*r, back) {
+		t.Errorf("round trip through %s gave %+v", data, back)
+	}
+
+	// Word lists that aren't built in need a resolver
+	custom, _ := NewWordList([]string{"alpha", "beta", "gamma"})
+	custom.Name = "greek"
+	r = NewWLRecipe(3, custom)
+	data, err = json.Marshal(r)
+	if err != nil {
+		t.Fatalf("couldn't marshal: %v", err)
+	}
+	if err := json.Unmarshal(data, &back); err == nil {
+		t.Errorf("%s shouldn't load without a resolver", data)
+	}
+	lists := func(name string) (*WordList, error) {
+		if name == "greek" {
+			return custom, nil
+		}
+		return nil, fmt.Errorf("no list %q", name)
+	}
+	g, err := ParseRecipe(data, lists)
+	if err != nil {
+		t.Fatalf("couldn't parse %s: %v", data, err)
+	}
+	if g.Entropy() != r.Entropy() {
+		t.Errorf("parsed recipe has entropy %f, not %f", g.Entropy(), r.Entropy())
+	}
+
+	// Separators from a character recipe
+	doc := `{"version": 1, "type": "words", "length": 3, "list": "greek", "separator": {"length": 2, "allow": ["digits"]}}`
+	g, err = ParseRecipe([]byte(doc), lists)
+	if err != nil {
+		t.Fatalf("couldn't parse %s: %v", doc, err)
+	}
+	if g.Entropy() != r.Entropy()+float32(entropySimple(4, 10)) {
+		t.Errorf("digit separators should add their entropy, got %f", g.Entropy())
+	}
+
+	// but separator functions can't be saved
+	r.SeparatorFunc = SFDigits1
+	if _, err := json.Marshal(r); err == nil {
+		t.Error("a recipe with a SeparatorFunc should not marshal")
+	}
+	unnamed, _ := NewWordList([]string{"one", "two"})
+	if _, err := json.Marshal(NewWLRecipe(2, unnamed)); err == nil {
+		t.Error("a recipe with an unnamed word list should not marshal")
+	}
+}
+
+func TestParseRecipeErrors(t *testing.T) {
+	docs := []string{
+		`{"version": 2, "type": "characters", "length": 4}`,
+		`{"type": "characters", "length": 4}`,
+		`{"version": 1, "type": "words", "length": 4, "list": "words", "separator": ["-"]}`,
+		`{"version": 1, "type": "words", "length": 4, "list": "words", "separator": {"length": 1, "allow": ["emoji"]}}`,
+		`{"version": 1, "type": "sentences", "length": 4}`,
+		`{"version": 1, "type": "characters", "length": 4, "allow": ["emoji"]}`,
+		`{"version": 1, "type": "words", "length": 4, "list": "klingon"}`,
+		`{"version": 1, "type": "words", "length": 4, "list": "words", "capitalize": "shouty"}`,
+		`not json`,
+	}
+	for _, doc := range docs {
+		if _, err := ParseRecipe([]byte(doc), nil); err == nil {
+			t.Errorf("%s should not parse", doc)
+		}
+	}
+
+	var r CharRecipe
+	if err := json.Unmarshal([]byte(`{"version": 1, "type": "words", "length": 4, "list": "words"}`), &r); err == nil {
+		t.Error("a word list recipe should not unmarshal into a CharRecipe")
+	}
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
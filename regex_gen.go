@@ -0,0 +1,399 @@
+package spg
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"regexp/syntax"
+	"sort"
+	"strconv"
+)
+
+/*** Regular expression passwords
+
+	Some sites have rules that don't fit into a CharRecipe, such as "two
+	letters, then digits, then a letter or a symbol". Those can often be
+	written as a regular expression, and we can generate uniformly from
+	all of the strings that match one.
+
+	Go's regexp/syntax compiles a pattern into a program that is
+	essentially a nondeterministic finite automaton (NFA). We turn that into
+	a deterministic one (DFA) over the alphabet, by the usual subset
+	construction. Counting the strings of length k that a DFA accepts is then
+	easy: it is the number of paths of length k from the start to an
+	accepting state, which we build up one length at a time. With those counts
+	we can find the i-th matching string just as charWalker does.
+
+***/
+
+// regexAlphabet is what regular expression passwords are made of,
+// printable ASCII other than space
+var regexAlphabet = func() []rune {
+	var abc []rune
+	for c := rune(0x21); c <= 0x7e; c++ {
+		abc = append(abc, c)
+	}
+	return abc
+}()
+
+// maxRegexStates limits how large a DFA we are willing to build
+const maxRegexStates = 10000
+
+// maxRegexLength limits how long regular expression passwords can be.
+// Counting keeps a row of counts for every length up to the longest.
+const maxRegexLength = 256
+
+// RegexRecipe is a recipe for passwords that match a regular expression.
+//
+// Pattern is in the syntax of Go's regexp package, and a password must match all of it
+// (it doesn't need to start with ^ nor end with $). Passwords are made only of printable
+// ASCII characters other than space, so something like "." means any one of those.
+// Anchors other than ^ and $, such as \b, aren't supported.
+//
+// Passwords are picked uniformly from all of the strings of MinLength to MaxLength
+// characters that match. MaxLength can be at most 256.
+//
+// Each call to Generate or Entropy builds the automaton for Pattern again.
+// When generating many passwords from the same recipe, Compile it first.
+type RegexRecipe struct {
+	Pattern   string // What passwords must match
+	MinLength int    // Shortest password, in characters
+	MaxLength int    // Longest password, in characters
+
+	Source RandomSource // Where random bytes come from. If nil, crypto/rand is used
+}
+
+// NewRegexRecipe creates a RegexRecipe for passwords of minLength to maxLength
+// characters that match pattern
+func NewRegexRecipe(pattern string, minLength, maxLength int) *RegexRecipe {
+	return &RegexRecipe{Pattern: pattern, MinLength: minLength, MaxLength: maxLength}
+}
+
+// regexDFA is a deterministic automaton for a pattern, along with
+// the number of ways to finish a matching string from each state
+type regexDFA struct {
+	start  int
+	accept []bool
+	next   [][]int      // next[s][i] is the state after regexAlphabet[i] from s, or -1
+	ways   [][]*big.Int // ways[k][s] is the number of strings of length k that take s to acceptance
+}
+
+// regexBuilder does the subset construction
+type regexBuilder struct {
+	prog   *syntax.Prog
+	states map[string]int
+	sets   [][]uint32
+}
+
+// closure returns the instructions that can be reached from pcs without reading
+// a character, given whether we are at the start of the string and at its end.
+// Anything that can't lead to a character or a match is dropped, as are
+// anchors that can't be met. Anchors for the end are kept until we know
+// whether we are at the end.
+func (b *regexBuilder) closure(pcs []uint32, atStart, atEnd bool) []uint32 {
+	seen := make(map[uint32]bool)
+	var out []uint32
+	var visit func(pc uint32)
+	visit = func(pc uint32) {
+		if seen[pc] {
+			return
+		}
+		seen[pc] = true
+		inst := &b.prog.Inst[pc]
+		switch inst.Op {
+		case syntax.InstAlt, syntax.InstAltMatch:
+			visit(inst.Out)
+			visit(inst.Arg)
+		case syntax.InstCapture, syntax.InstNop:
+			visit(inst.Out)
+		case syntax.InstEmptyWidth:
+			op := syntax.EmptyOp(inst.Arg)
+			begin := op & (syntax.EmptyBeginLine | syntax.EmptyBeginText)
+			end := op & (syntax.EmptyEndLine | syntax.EmptyEndText)
+			switch {
+			case begin != 0 && (!atStart || end != 0):
+				// Passwords are never empty, so nothing is both at the start and the end
+			case end != 0 && !atEnd:
+				out = append(out, pc)
+			default:
+				visit(inst.Out)
+			}
+		case syntax.InstRune, syntax.InstRune1, syntax.InstRuneAny, syntax.InstRuneAnyNotNL, syntax.InstMatch:
+			out = append(out, pc)
+		}
+	}
+	for _, pc := range pcs {
+		visit(pc)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// state returns the DFA state for the set of instructions pcs, adding it if it is new
+func (b *regexBuilder) state(pcs []uint32) (int, error) {
+	key := fmt.Sprint(pcs)
+	if s, ok := b.states[key]; ok {
+		return s, nil
+	}
+	if len(b.sets) >= maxRegexStates {
+		return 0, fmt.Errorf("%w: regular expression is too complex", ErrUnsupported)
+	}
+	b.states[key] = len(b.sets)
+	b.sets = append(b.sets, pcs)
+	return len(b.sets) - 1, nil
+}
+
+// matchRune tells whether inst reads c
+func matchRune(inst *syntax.Inst, c rune) bool {
+	switch inst.Op {
+	case syntax.InstRune:
+		return inst.MatchRune(c)
+	case syntax.InstRune1:
+		return c == inst.Rune[0]
+	case syntax.InstRuneAny:
+		return true
+	case syntax.InstRuneAnyNotNL:
+		return c != '\n'
+	}
+	return false
+}
+
+// compileRegex builds the DFA for pattern, with counts for lengths up to maxLength
+func compileRegex(pattern string, maxLength int) (*regexDFA, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad regular expression: %v", ErrSyntax, err)
+	}
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad regular expression: %v", ErrSyntax, err)
+	}
+	for _, inst := range prog.Inst {
+		if inst.Op == syntax.InstEmptyWidth &&
+			syntax.EmptyOp(inst.Arg)&(syntax.EmptyWordBoundary|syntax.EmptyNoWordBoundary) != 0 {
+			return nil, fmt.Errorf(`%w: word boundaries (\b and \B)`, ErrUnsupported)
+		}
+	}
+
+	b := &regexBuilder{prog: prog, states: make(map[string]int)}
+	d := &regexDFA{}
+	if d.start, err = b.state(b.closure([]uint32{uint32(prog.Start)}, true, false)); err != nil {
+		return nil, err
+	}
+
+	// The set of states grows as we go through it
+	for s := 0; s < len(b.sets); s++ {
+		row := make([]int, len(regexAlphabet))
+		for i, c := range regexAlphabet {
+			var outs []uint32
+			for _, pc := range b.sets[s] {
+				if inst := &prog.Inst[pc]; matchRune(inst, c) {
+					outs = append(outs, inst.Out)
+				}
+			}
+			row[i] = -1
+			if pcs := b.closure(outs, false, false); len(pcs) > 0 {
+				if row[i], err = b.state(pcs); err != nil {
+					return nil, err
+				}
+			}
+		}
+		d.next = append(d.next, row)
+
+		accept := false
+		for _, pc := range b.closure(b.sets[s], false, true) {
+			accept = accept || prog.Inst[pc].Op == syntax.InstMatch
+		}
+		d.accept = append(d.accept, accept)
+	}
+
+	d.count(maxLength)
+	return d, nil
+}
+
+// count fills in d.ways up to maxLength
+func (d *regexDFA) count(maxLength int) {
+	// Many characters usually lead to the same state,
+	// so we only need to count each target once
+	type edge struct{ to, mult int }
+	edges := make([][]edge, len(d.next))
+	for s, row := range d.next {
+		mult := make(map[int]int)
+		var targets []int
+		for _, t := range row {
+			if t < 0 {
+				continue
+			}
+			if mult[t] == 0 {
+				targets = append(targets, t)
+			}
+			mult[t]++
+		}
+		for _, t := range targets {
+			edges[s] = append(edges[s], edge{t, mult[t]})
+		}
+	}
+
+	d.ways = make([][]*big.Int, maxLength+1)
+	d.ways[0] = bigZeros(len(d.next))
+	for s, ok := range d.accept {
+		if ok {
+			d.ways[0][s].SetInt64(1)
+		}
+	}
+	term := new(big.Int)
+	for k := 1; k <= maxLength; k++ {
+		d.ways[k] = bigZeros(len(d.next))
+		for s := range d.next {
+			for _, e := range edges[s] {
+				term.Mul(big.NewInt(int64(e.mult)), d.ways[k-1][e.to])
+				d.ways[k][s].Add(d.ways[k][s], term)
+			}
+		}
+	}
+}
+
+// check makes sure the lengths make sense and builds the DFA
+func (r RegexRecipe) check() (*regexDFA, error) {
+	if r.MinLength < 1 {
+		return nil, &RecipeError{Problem: ProblemBadLength, Length: r.MinLength}
+	}
+	if r.MaxLength < r.MinLength {
+		return nil, fmt.Errorf("%w: maximum length (%d) is less than minimum length (%d)", ErrBadLength, r.MaxLength, r.MinLength)
+	}
+	if r.MaxLength > maxRegexLength {
+		return nil, fmt.Errorf("%w: maximum length (%d) is more than %d", ErrBadLength, r.MaxLength, maxRegexLength)
+	}
+	return compileRegex(r.Pattern, r.MaxLength)
+}
+
+// total is the number of matching strings with lengths from minLength to maxLength
+func (d *regexDFA) total(minLength, maxLength int) *big.Int {
+	n := new(big.Int)
+	for k := minLength; k <= maxLength; k++ {
+		n.Add(n, d.ways[k][d.start])
+	}
+	return n
+}
+
+// unrank returns the idx-th of the matching strings, with shorter ones
+// first and in alphabetical order within each length
+func (d *regexDFA) unrank(idx *big.Int, minLength, maxLength int) (Tokens, error) {
+	rem := new(big.Int).Set(idx)
+	length := minLength
+	for ; length <= maxLength; length++ {
+		if rem.Cmp(d.ways[length][d.start]) < 0 {
+			break
+		}
+		rem.Sub(rem, d.ways[length][d.start])
+	}
+	if rem.Sign() < 0 || length > maxLength {
+		return nil, fmt.Errorf("%w: %v", ErrIndexOutOfRange, idx)
+	}
+
+	tokens := make(Tokens, 0, length)
+	s := d.start
+	for left := length; left > 0; left-- {
+		found := false
+		for i, t := range d.next[s] {
+			if t < 0 {
+				continue
+			}
+			cnt := d.ways[left-1][t]
+			if rem.Cmp(cnt) < 0 {
+				tokens = append(tokens, Token{string(regexAlphabet[i]), AtomType})
+				s = t
+				found = true
+				break
+			}
+			rem.Sub(rem, cnt)
+		}
+		if !found {
+			return nil, errCounting("ran out of characters with %d left", left)
+		}
+	}
+	return tokens, nil
+}
+
+// CompiledRegexRecipe is a RegexRecipe whose automaton has been built,
+// ready for generating many passwords. Create one with RegexRecipe.Compile.
+// Nothing in it changes after that, so it is safe to use from many goroutines at once.
+type CompiledRegexRecipe struct {
+	r     RegexRecipe
+	dfa   *regexDFA
+	total *big.Int // number of matching strings
+}
+
+// Compile checks r and builds what is needed to generate passwords from it
+func (r RegexRecipe) Compile() (*CompiledRegexRecipe, error) {
+	d, err := r.check()
+	if err != nil {
+		return nil, err
+	}
+	total := d.total(r.MinLength, r.MaxLength)
+	if total.Sign() == 0 {
+		return nil, fmt.Errorf("%w: nothing of length %d to %d matches %s", ErrImpossibleRequirements,
+			r.MinLength, r.MaxLength, strconv.Quote(r.Pattern))
+	}
+	return &CompiledRegexRecipe{r: r, dfa: d, total: total}, nil
+}
+
+// Recipe returns a copy of the recipe that c was compiled from
+func (c *CompiledRegexRecipe) Recipe() RegexRecipe {
+	return c.r
+}
+
+// Entropy returns the entropy of passwords from c
+func (c *CompiledRegexRecipe) Entropy() float32 {
+	return log2Big(c.total)
+}
+
+// Generate a password from c, with randomness from the Source of the recipe
+func (c *CompiledRegexRecipe) Generate() (*Password, error) {
+	return c.GenerateFrom(c.r.Source)
+}
+
+// GenerateFrom is like Generate, but draws its randomness from rs.
+// A nil rs means crypto/rand.
+func (c *CompiledRegexRecipe) GenerateFrom(rs RandomSource) (*Password, error) {
+	idx, err := randomBigIntn(rs, c.total)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := c.dfa.unrank(idx, c.r.MinLength, c.r.MaxLength)
+	if err != nil {
+		return nil, err
+	}
+	return &Password{tokens: tokens, Entropy: c.Entropy()}, nil
+}
+
+// Generate a password that matches r.Pattern
+func (r RegexRecipe) Generate() (*Password, error) {
+	return r.GenerateFrom(r.Source)
+}
+
+// GenerateFrom is like Generate, but draws its randomness from rs
+// instead of from r.Source. A nil rs means crypto/rand.
+func (r RegexRecipe) GenerateFrom(rs RandomSource) (*Password, error) {
+	c, err := r.Compile()
+	if err != nil {
+		return nil, err
+	}
+	return c.GenerateFrom(rs)
+}
+
+// Entropy returns the entropy of passwords from r. That is log2 of the
+// number of strings that match. It is NaN if r can't be used, including
+// when nothing matches.
+func (r RegexRecipe) Entropy() float32 {
+	c, err := r.Compile()
+	if err != nil {
+		return float32(math.NaN())
+	}
+	return c.Entropy()
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
@@ -0,0 +1,151 @@
+package spg
+
+import (
+	"math"
+	"regexp"
+	"testing"
+)
+
+func TestRegexCounts(t *testing.T) {
+	vecs := []struct {
+		pattern  string
+		min, max int
+		n        float64
+	}{
+		{`[0-9]{4}`, 4, 4, 10000},
+		{`^[0-9]{4}$`, 1, 8, 10000},
+		{`[a-z][0-9]+`, 2, 3, 26*10 + 26*100},
+		{`.`, 1, 1, 94},
+		{`(?i)ab`, 2, 2, 4},
+		{`a|bb|[cd]`, 1, 2, 4},
+		{`[^a-z]`, 1, 1, 94 - 26},
+		{`a*`, 1, 3, 3},
+	}
+	for _, v := range vecs {
+		r := NewRegexRecipe(v.pattern, v.min, v.max)
+		want := float32(math.Log2(v.n))
+		if got := r.Entropy(); got != want {
+			t.Errorf("%q (%d-%d): entropy should be %f, was %f", v.pattern, v.min, v.max, want, got)
+		}
+	}
+}
+
+func TestRegexNothingMatches(t *testing.T) {
+	recipes := []*RegexRecipe{
+		NewRegexRecipe(`a`, 2, 2),
+		NewRegexRecipe(` `, 1, 1), // no spaces in the alphabet
+		NewRegexRecipe(`a(?m)$b`, 1, 4),
+		NewRegexRecipe(`a+`, 1, maxRegexLength+1),
+	}
+	for _, r := range recipes {
+		if e := r.Entropy(); !math.IsNaN(float64(e)) {
+			t.Errorf("%q (%d-%d): entropy should be NaN, was %f", r.Pattern, r.MinLength, r.MaxLength, e)
+		}
+	}
+}
+
+func TestRegexBruteForce(t *testing.T) {
+	patterns := []string{`[a-c]+1?`, `(ab|a)(bc|c)?`, `\d\D`, `[[:punct:]]x|y.`, `a$|b`, `(x|^y)z`}
+	for _, p := range patterns {
+		re := regexp.MustCompile(`^(?:` + p + `)$`)
+		count := 0
+		for _, a := range regexAlphabet {
+			if re.MatchString(string(a)) {
+				count++
+			}
+			for _, b := range regexAlphabet {
+				if re.MatchString(string(a) + string(b)) {
+					count++
+				}
+			}
+		}
+		d, err := compileRegex(p, 2)
+		if err != nil {
+			t.Fatalf("couldn't compile %q: %v", p, err)
+		}
+		if got := d.total(1, 2).Int64(); got != int64(count) {
+			t.Errorf("%q: counted %d, brute force gives %d", p, got, count)
+		}
+	}
+}
+
+func TestRegexGenerate(t *testing.T) {
+	pattern := `[A-Z]{2}[0-9]{2,4}[a-z!@#]`
+	re := regexp.MustCompile(`^(?:` + pattern + `)$`)
+	r := NewRegexRecipe(pattern, 4, 10)
+	r.Source = newDetSource("regex")
+	for i := 0; i < 50; i++ {
+		p, err := r.Generate()
+		if err != nil {
+			t.Fatalf("failed to generate: %v", err)
+		}
+		if !re.MatchString(p.String()) {
+			t.Errorf("%q doesn't match %q", p, pattern)
+		}
+		if p.Entropy != r.Entropy() {
+			t.Errorf("password entropy (%f) should be recipe entropy (%f)", p.Entropy, r.Entropy())
+		}
+	}
+
+	// Compiled or not, the same source gives the same password
+	c, err := r.Compile()
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	if c.Entropy() != r.Entropy() {
+		t.Errorf("compiled entropy %f, recipe entropy %f", c.Entropy(), r.Entropy())
+	}
+	a, err := c.GenerateFrom(newDetSource("compile"))
+	if err != nil {
+		t.Fatalf("failed to generate: %v", err)
+	}
+	b, err := r.GenerateFrom(newDetSource("compile"))
+	if err != nil {
+		t.Fatalf("failed to generate: %v", err)
+	}
+	if a.String() != b.String() || a.Entropy != b.Entropy {
+		t.Errorf("compiled gave %q, recipe gave %q", a, b)
+	}
+
+	// Shorter and longer passwords should be just as likely as each other
+	r = NewRegexRecipe(`a|bb|[cd]`, 1, 2)
+	counts := make(map[string]int)
+	rs := newDetSource("uniform")
+	trials := 4000
+	for i := 0; i < trials; i++ {
+		p, err := r.GenerateFrom(rs)
+		if err != nil {
+			t.Fatalf("failed to generate: %v", err)
+		}
+		counts[p.String()]++
+	}
+	if len(counts) != 4 {
+		t.Errorf("expected 4 distinct passwords, got %v", counts)
+	}
+	for pw, c := range counts {
+		if c < trials/4-150 || c > trials/4+150 {
+			t.Errorf("%q appeared %d times out of %d", pw, c, trials)
+		}
+	}
+}
+
+func TestRegexErrors(t *testing.T) {
+	recipes := []*RegexRecipe{
+		NewRegexRecipe(`[a-`, 1, 4),
+		NewRegexRecipe(`\bword`, 1, 4),
+		NewRegexRecipe(`a+`, 0, 4),
+		NewRegexRecipe(`a+`, 4, 3),
+		NewRegexRecipe(`a{3}`, 1, 2),
+		NewRegexRecipe(`a+`, 1, maxRegexLength+1),
+	}
+	for _, r := range recipes {
+		if _, err := r.Generate(); err == nil {
+			t.Errorf("%q (%d-%d) should not generate", r.Pattern, r.MinLength, r.MaxLength)
+		}
+	}
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
@@ -0,0 +1,136 @@
+package spg
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// Separator makes what goes between the words of a WLRecipe. Next returns a
+// separator, drawing any randomness it needs from rs (nil means crypto/rand).
+// Entropy is the entropy that each separator adds, and must not generate
+// anything to find that out.
+//
+// MarshalJSON describes the separator, so that recipes using it can be saved.
+// A JSON string is a literal separator, and an object is a character recipe
+// (in the same form as the fields of a saved CharRecipe).
+type Separator interface {
+	Next(rs RandomSource) (string, error)
+	Entropy() float32
+	json.Marshaler
+}
+
+// LiteralSeparator is a separator that is always the same string
+type LiteralSeparator string
+
+// Next returns s
+func (s LiteralSeparator) Next(rs RandomSource) (string, error) {
+	return string(s), nil
+}
+
+// Entropy is zero, as there is no choice in a literal separator
+func (s LiteralSeparator) Entropy() float32 {
+	return 0
+}
+
+// MarshalJSON describes s as a JSON string
+func (s LiteralSeparator) MarshalJSON() ([]byte, error) {
+	return json.Marshal(string(s))
+}
+
+// CharSeparator is a separator made by generating a password from Recipe,
+// such as one or two digits
+type CharSeparator struct {
+	Recipe CharRecipe
+}
+
+// NewCharSeparator creates a CharSeparator from r
+func NewCharSeparator(r CharRecipe) *CharSeparator {
+	return &CharSeparator{Recipe: r}
+}
+
+// Next generates a separator from s.Recipe using rs
+func (s CharSeparator) Next(rs RandomSource) (string, error) {
+	p, err := s.Recipe.GenerateFrom(rs)
+	if err != nil {
+		return "", err
+	}
+	return p.String(), nil
+}
+
+// Entropy is the entropy of s.Recipe
+func (s CharSeparator) Entropy() float32 {
+	return s.Recipe.Entropy()
+}
+
+// MarshalJSON describes s as the fields of its recipe
+func (s CharSeparator) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Recipe.fields())
+}
+
+// Pre-baked separators. They are what the SF presets such as SFDigits1
+// make, but report their entropy without generating anything.
+var (
+	SepDigits1            = CharSeparator{Recipe: CharRecipe{Length: 1, Allow: Digits}}                     // Single digit separator
+	SepDigits2            = CharSeparator{Recipe: CharRecipe{Length: 2, Allow: Digits}}                     // Double digit separator
+	SepDigitsNoAmbiguous1 = CharSeparator{Recipe: CharRecipe{Length: 1, Allow: Digits, Exclude: Ambiguous}} // Single digit, no ambiguous
+	SepDigitsNoAmbiguous2 = CharSeparator{Recipe: CharRecipe{Length: 2, Allow: Digits, Exclude: Ambiguous}} // Double digit, no ambiguous
+	SepSymbols            = CharSeparator{Recipe: CharRecipe{Length: 1, Allow: Symbols}}                    // Symbols
+	SepDigitsSymbols      = CharSeparator{Recipe: CharRecipe{Length: 1, Allow: Symbols | Digits}}           // Symbols and digits
+)
+
+// sfSeparator adapts an SFFunction to a Separator
+type sfSeparator struct {
+	sf SFFunction
+}
+
+// SeparatorFromSF adapts sf to a Separator. An SFFunction can only report
+// its entropy along with a separator, so Entropy of the result has to make
+// (and throw away) a separator, and Next can't use the RandomSource it is
+// given. Nor can it be saved. Use LiteralSeparator, CharSeparator, or the
+// presets such as SepDigits1 (in place of SFDigits1) where possible.
+func SeparatorFromSF(sf SFFunction) Separator {
+	return sfSeparator{sf: sf}
+}
+
+func (s sfSeparator) Next(rs RandomSource) (string, error) {
+	sep, _, err := s.sf()
+	return sep, err
+}
+
+func (s sfSeparator) Entropy() float32 {
+	_, ent, err := s.sf()
+	if err != nil {
+		return float32(math.NaN())
+	}
+	return float32(ent)
+}
+
+func (s sfSeparator) MarshalJSON() ([]byte, error) {
+	return nil, fmt.Errorf("%w: can't save a separator from an SFFunction", ErrUnsupported)
+}
+
+// separator is the Separator that r uses. Separator wins over SeparatorFunc,
+// which wins over SeparatorChar.
+func (r WLRecipe) separator() Separator {
+	switch {
+	case r.Separator != nil:
+		return r.Separator
+	case r.SeparatorFunc != nil:
+		return SeparatorFromSF(r.SeparatorFunc)
+	}
+	return LiteralSeparator(r.SeparatorChar)
+}
+
+// literalSeparator returns the separator of r if it is always the same
+func (r WLRecipe) literalSeparator() (string, bool) {
+	if s, ok := r.separator().(LiteralSeparator); ok {
+		return string(s), true
+	}
+	return "", false
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
@@ -0,0 +1,152 @@
+package spg
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSeparators(t *testing.T) {
+	wl, err := NewWordList([]string{"one", "two", "three", "four"})
+	if err != nil {
+		t.Fatalf("failed to create word list: %v", err)
+	}
+	digits := CharRecipe{Length: 2, Allow: Digits}
+	r := NewWLRecipe(3, wl)
+
+	// Entropy of a character separator comes from its recipe,
+	// without drawing anything from a source
+	r.Separator = NewCharSeparator(digits)
+	r.Source = failingSource{}
+	want := float32(entropySimple(3, 4) + 2*entropySimple(2, 10))
+	if cmpFloat32(r.Entropy(), want, 100000) != 0 {
+		t.Errorf("entropy should be %f, not %f", want, r.Entropy())
+	}
+
+	// Separators come from the same source as the words
+	r.Source = newDetSource("separators")
+	a, err := r.Generate()
+	if err != nil {
+		t.Fatalf("failed to generate: %v", err)
+	}
+	b, err := r.GenerateFrom(newDetSource("separators"))
+	if err != nil {
+		t.Fatalf("failed to generate: %v", err)
+	}
+	if a.String() != b.String() {
+		t.Errorf("same source gave different passwords: %q and %q", a, b)
+	}
+	seps := a.Tokens().Separators()
+	if len(seps) != 2 || len(seps[0]) != 2 || strings.Trim(seps[0]+seps[1], ctDigits) != "" {
+		t.Errorf("%q should have two digit separators", a)
+	}
+
+	// Separator wins over SeparatorChar and SeparatorFunc
+	r.SeparatorChar = "-"
+	r.SeparatorFunc = SFSymbols
+	r.Separator = LiteralSeparator("+")
+	p, err := r.Generate()
+	if err != nil {
+		t.Fatalf("failed to generate: %v", err)
+	}
+	if strings.Count(p.String(), "+") != 2 || r.Entropy() != float32(entropySimple(3, 4)) {
+		t.Errorf("%q (entropy %f) should be separated by +", p, r.Entropy())
+	}
+
+	// and literal separators can be ranked
+	idx, err := r.Rank(p)
+	if err != nil {
+		t.Fatalf("couldn't rank %q: %v", p, err)
+	}
+	if q, err := r.Unrank(idx); err != nil || q.String() != p.String() {
+		t.Errorf("unranking %v gave %v (%v), not %q", idx, q, err, p)
+	}
+	r.Separator = SeparatorFromSF(SFDigits1)
+	if _, err := r.Unrank(big.NewInt(0)); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("a separator from an SFFunction shouldn't be rankable, got %v", err)
+	}
+
+	// Each SF preset has a Separator that makes the same separators
+	presets := []struct {
+		sep Separator
+		sf  SFFunction
+	}{
+		{SepDigits1, SFDigits1},
+		{SepDigits2, SFDigits2},
+		{SepDigitsNoAmbiguous1, SFDigitsNoAmbiguous1},
+		{SepDigitsNoAmbiguous2, SFDigitsNoAmbiguous2},
+		{SepSymbols, SFSymbols},
+		{SepDigitsSymbols, SFDigitsSymbols},
+	}
+	for i, v := range presets {
+		if cmpFloat32(v.sep.Entropy(), SeparatorFromSF(v.sf).Entropy(), 100000) != 0 {
+			t.Errorf("%d: preset has entropy %f, SF preset %f", i, v.sep.Entropy(), SeparatorFromSF(v.sf).Entropy())
+		}
+		s, err := v.sep.Next(newDetSource("presets"))
+		if err != nil {
+			t.Fatalf("%d: failed to make a separator: %v", i, err)
+		}
+		if want := v.sep.(CharSeparator).Recipe; !meetsRecipe(want, s) {
+			t.Errorf("%d: %q isn't a separator from the preset", i, s)
+		}
+	}
+
+	// SFFunctions can be adapted, but not saved
+	sep := SeparatorFromSF(SFDigits1)
+	if s, err := sep.Next(nil); err != nil || len(s) != 1 {
+		t.Errorf("bad separator %q (%v)", s, err)
+	}
+	if cmpFloat32(sep.Entropy(), float32(entropySimple(1, 10)), 100000) != 0 {
+		t.Errorf("adapted SFDigits1 has entropy %f", sep.Entropy())
+	}
+	if _, err := json.Marshal(sep); err == nil {
+		t.Error("an adapted SFFunction should not marshal")
+	}
+}
+
+func TestSeparatorJSON(t *testing.T) {
+	wl, err := BuiltinWordList("words")
+	if err != nil {
+		t.Fatalf("no words: %v", err)
+	}
+	r := NewWLRecipe(4, wl)
+	r.Separator = NewCharSeparator(CharRecipe{Length: 2, Allow: Digits | Symbols, Exclude: Ambiguous})
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("couldn't marshal: %v", err)
+	}
+	var back WLRecipe
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatalf("couldn't unmarshal %s: %v", data, err)
+	}
+	if !reflect.DeepEqual(*r, back) {
+		t.Errorf("round trip through %s gave %+v", data, back)
+	}
+
+	// A literal Separator is saved as a string, and comes back as SeparatorChar
+	r.Separator = LiteralSeparator(".")
+	data, err = json.Marshal(r)
+	if err != nil {
+		t.Fatalf("couldn't marshal: %v", err)
+	}
+	if !strings.Contains(string(data), `"separator":"."`) {
+		t.Errorf("%s should have a literal separator", data)
+	}
+	if err := json.Unmarshal(data, &back); err != nil || back.SeparatorChar != "." || back.Separator != nil {
+		t.Errorf("%s gave %+v (%v)", data, back, err)
+	}
+
+	bad := `{"version": 1, "type": "words", "length": 4, "list": "words", "separator": 7}`
+	if err := json.Unmarshal([]byte(bad), &back); !errors.Is(err, ErrInvalidRecipe) {
+		t.Errorf("%s should not unmarshal, got %v", bad, err)
+	}
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
@@ -107,7 +107,7 @@ func (ts Tokens) MakeIndices() (Indices, error) {
 			v := tok.Value()
 			lng := len(v)
 			if lng > math.MaxUint8 {
-				return nil, fmt.Errorf("token too large (%d)", lng)
+				return nil, fmt.Errorf("%w (%d)", ErrTokenTooLong, lng)
 			}
 			ti[i] = uint8(lng)
 		}
@@ -126,7 +126,7 @@ func (ts Tokens) MakeIndices() (Indices, error) {
 			lng := len(v)
 			tt := tok.Type()
 			if lng > math.MaxUint8 {
-				return nil, fmt.Errorf("token too large (%d)", lng)
+				return nil, fmt.Errorf("%w (%d)", ErrTokenTooLong, lng)
 			}
 			ti[2*i] = uint8(lng)
 			ti[(2*i)+1] = byte(tt)
@@ -202,7 +202,7 @@ func Tokenize(pw string, ti Indices, entropy float32) (Password, error) {
 	chars := strings.Split(pw, "")
 
 	if len(ti) == 0 {
-		return p, fmt.Errorf("tokenization must begin with a TI Kind byte")
+		return p, fmt.Errorf("%w: tokenization must begin with a TI Kind byte", ErrMalformedIndices)
 	}
 
 	kind := IndexKind(ti[0])
@@ -222,7 +222,7 @@ func Tokenize(pw string, ti Indices, entropy float32) (Password, error) {
 		for i, tl := range ti[1:] {
 			newPos := prevPos + int(tl)
 			if newPos > len(chars) {
-				return p, fmt.Errorf("password too short for indices")
+				return p, fmt.Errorf("%w: password too short for indices", ErrMalformedIndices)
 			}
 			v := strings.Join(chars[prevPos:newPos], "")
 			tokens[i] = Token{v, AtomType}
@@ -238,7 +238,7 @@ func Tokenize(pw string, ti Indices, entropy float32) (Password, error) {
 		for i, tl := range ti[1:] {
 			newPos := prevPos + int(tl)
 			if newPos > len(chars) {
-				return p, fmt.Errorf("password too short for indices")
+				return p, fmt.Errorf("%w: password too short for indices", ErrMalformedIndices)
 			}
 			v := strings.Join(chars[prevPos:newPos], "")
 			tt := AtomType
@@ -252,6 +252,10 @@ func Tokenize(pw string, ti Indices, entropy float32) (Password, error) {
 		return p, nil
 
 	case FullIndexKind:
+		// A length and a type for each token
+		if len(ti)%2 != 1 {
+			return p, fmt.Errorf("%w: odd number of bytes for lengths and types", ErrMalformedIndices)
+		}
 		tokens := make([]Token, len(ti)/2)
 
 		prevPos := 0
@@ -260,7 +264,7 @@ func Tokenize(pw string, ti Indices, entropy float32) (Password, error) {
 			tt := int(ti[i+1])
 			newPos := prevPos + tl
 			if newPos > len(chars) {
-				return p, fmt.Errorf("password too short for indices")
+				return p, fmt.Errorf("%w: password too short for indices", ErrMalformedIndices)
 			}
 			v := strings.Join(chars[prevPos:newPos], "")
 			tokens[i/2] = Token{v, TokenType(tt)}
@@ -269,7 +273,7 @@ func Tokenize(pw string, ti Indices, entropy float32) (Password, error) {
 		p.tokens = tokens
 		return p, nil
 	default:
-		return p, fmt.Errorf("Unknown TIIndex kind: %d", kind)
+		return p, fmt.Errorf("%w: unknown TIIndex kind: %d", ErrMalformedIndices, kind)
 	}
 }
 
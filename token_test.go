@@ -2,6 +2,8 @@ package spg
 
 import (
 	"bytes"
+	"errors"
+	"strings"
 	"testing"
 )
 
@@ -139,6 +141,30 @@ func TestTokenizer(t *testing.T) {
 	}
 }
 
+func TestTokenizeErrors(t *testing.T) {
+	vecs := []struct {
+		pw string
+		ti Indices
+	}{
+		{"abc", Indices{}},
+		{"abc", Indices{42}},
+		{"abc", Indices{byte(VarAtomsIndexKind), 2, 2}},
+		{"abc", Indices{byte(AlternatingIndexKind), 1, 1, 2}},
+		{"abc", Indices{byte(FullIndexKind), 3}},
+		{"abc", Indices{byte(FullIndexKind), 3, byte(AtomType), 1, byte(AtomType)}},
+	}
+	for i, v := range vecs {
+		if _, err := Tokenize(v.pw, v.ti, 0); !errors.Is(err, ErrMalformedIndices) {
+			t.Errorf("%d: expected ErrMalformedIndices, got %v", i, err)
+		}
+	}
+
+	long := Tokens{{strings.Repeat("x", 300), AtomType}, {"-", SeparatorType}, {"y", AtomType}}
+	if _, err := long.MakeIndices(); !errors.Is(err, ErrTokenTooLong) {
+		t.Errorf("expected ErrTokenTooLong, got %v", err)
+	}
+}
+
 /**
  ** Copyright 2018 AgileBits, Inc.
  ** Licensed under the Apache License, Version 2.0 (the "License").
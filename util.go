@@ -4,7 +4,9 @@ import (
 	rand "crypto/rand"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"math"
+	"math/big"
 	"strings"
 )
 
@@ -21,32 +23,56 @@ func subtractString(source, remove string) string {
 }
 
 // nFromString picks characters from a sting. This is for internal use only. It does not check for duplicates in the string
-func nFromString(ab string, n int) (string, float64) {
+func nFromString(rs RandomSource, ab string, n int) (string, float64, error) {
 	if len(ab) == 0 {
-		return "", 0.0
+		return "", 0.0, nil
 	}
 	if n < 1 {
-		return "", 0.0
+		return "", 0.0, nil
 	}
 	ent := float64(n) * math.Log2(float64(len(ab)))
 	sep := ""
 	rAB := strings.Split(ab, "") // an AlphaBet of runes
 	for i := 1; i <= n; i++ {
-		sep += string(rAB[randomUint32n(uint32(len(rAB)))])
+		j, err := randomUint32n(rs, uint32(len(rAB)))
+		if err != nil {
+			return "", 0.0, err
+		}
+		sep += string(rAB[j])
 	}
-	return sep, ent
+	return sep, ent, nil
 
 }
 
-// randomUint32 creates a random 32 bit unsigned integer
-func randomUint32() uint32 {
+// randomUint32 creates a random 32 bit unsigned integer from rs
+// (or from crypto/rand if rs is nil)
+func randomUint32(rs RandomSource) (uint32, error) {
 	b := make([]byte, 4)
-	_, err := rand.Read(b)
+	_, err := io.ReadFull(sourceOrDefault(rs), b)
 	if err != nil {
-		panic("PRNG gen error:" + err.Error())
+		return 0, fmt.Errorf("%w: %v", ErrRandomSource, err)
 	}
 
-	return binary.BigEndian.Uint32(b)
+	return binary.BigEndian.Uint32(b), nil
+}
+
+// log2Big is log2(n) for n that may be too large for a float64
+func log2Big(n *big.Int) float32 {
+	floatValue := big.NewFloat(0).SetInt(n)
+
+	// big.Float doesn't have a Log function, so we need to use a float64.
+	// See https://github.com/golang/go/issues/14102
+	// Avoid float64 overflows by splitting up the mantissa and exponent.
+	mantissa := big.NewFloat(0)
+	expo := floatValue.MantExp(mantissa)
+	float64Mantissa, _ := mantissa.Float64()
+
+	// Fun log math:
+	//   log2(intValue) =
+	//   log2(intValue / 2 ** N * 2 ** N) =
+	//   log2(intValue / 2 ** N) + log2(2 ** N) =
+	//   log2(intValue / 2 ** N) + N
+	return float32(math.Log2(float64Mantissa) + float64(expo))
 }
 
 // entropySimple takes the password length and the number of elements in the alphabet
@@ -66,24 +92,45 @@ func entropySimple(length int, nelem int) FloatE {
 	return FloatE(float64(length) * entPerUnit)
 }
 
-// randomUint32n returns, as a uint32, a non-negative random number in [0,n) from a cryptographic appropriate source.
-// It panics if a security-sensitive random number cannot be created or if n == 0.
+// randomUint32n returns, as a uint32, a non-negative random number in [0,n) from rs,
+// which should be a cryptographic appropriate source. A nil rs means crypto/rand.
+// It returns an error if a security-sensitive random number cannot be created or if n == 0.
 // Care is taken to avoid modulo bias.
 //
 // Based on Int31n from the math/rand package..
-func randomUint32n(n uint32) uint32 {
+func randomUint32n(rs RandomSource, n uint32) (uint32, error) {
 	if n < 1 {
-		panic("randomUint32n called with 0")
+		return 0, fmt.Errorf("randomUint32n called with 0")
 	}
 	if n&(n-1) == 0 { // n is power of two, can mask
-		return randomUint32() & (n - 1)
+		v, err := randomUint32(rs)
+		return v & (n - 1), err
 	}
 	discard := uint32(math.MaxUint32 - math.MaxUint32%n)
-	v := randomUint32()
-	for v >= discard {
-		v = randomUint32()
+	for {
+		v, err := randomUint32(rs)
+		if err != nil {
+			return 0, err
+		}
+		if v < discard {
+			return v % n, nil
+		}
+	}
+}
+
+// randomBigIntn returns a uniform random number in [0,n) from rs
+// (or from crypto/rand if rs is nil).
+// It returns an error if a security-sensitive random number cannot be created or if n < 1.
+func randomBigIntn(rs RandomSource, n *big.Int) (*big.Int, error) {
+	if n.Sign() < 1 {
+		return nil, fmt.Errorf("randomBigIntn called with n < 1")
+	}
+	// rand.Int does its own rejection to avoid modulo bias
+	v, err := rand.Int(sourceOrDefault(rs), n)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRandomSource, err)
 	}
-	return v % n
+	return v, nil
 }
 
 /**
@@ -0,0 +1,188 @@
+package spg
+
+import (
+	"fmt"
+	"math/big"
+	"unicode/utf8"
+)
+
+/*** Bounds on the number of characters in word list passwords
+
+	A WLRecipe with MinChars or MaxChars only produces passwords whose
+	length in characters (separators included) is within the bounds.
+	Throwing away passwords that don't fit would be uniform, but it can take
+	many tries, and the entropy would no longer be what the recipe says.
+
+	Instead we count. Separators are all the same length, so what matters is
+	the number of characters in the words. With h[l] words of length l on the
+	list, the number of ways to finish a password with k more words, when the
+	words so far have c characters, is
+
+		fit[0][c] = 1 if c is within the bounds, otherwise 0
+		fit[k][c] = sum over l of h[l] * fit[k-1][c+l]
+
+	and fit[Length][0] is the number of passwords that fit. They are lined up
+	(by the lengths of the words, then by the words) and one is picked
+	uniformly by its position, just as for character recipes.
+
+***/
+
+// limitsChars is true if r has bounds on the number of characters
+func (r WLRecipe) limitsChars() bool {
+	return r.MinChars > 0 || r.MaxChars > 0
+}
+
+// separatorLength is the number of characters in each separator of r,
+// if they are all the same length
+func (r WLRecipe) separatorLength() (int, bool) {
+	switch s := r.separator().(type) {
+	case LiteralSeparator:
+		return utf8.RuneCountInString(string(s)), true
+	case CharSeparator:
+		return s.Recipe.Length, true
+	case *CharSeparator:
+		return s.Recipe.Length, true
+	}
+	return 0, false
+}
+
+// charsError reports bounds on characters that can't be used
+func (r WLRecipe) charsError() error {
+	if !r.limitsChars() {
+		return nil
+	}
+	if r.MinChars < 0 || r.MaxChars < 0 || (r.MaxChars > 0 && r.MinChars > r.MaxChars) {
+		return &RecipeError{Problem: ProblemBadBounds, Set: "characters",
+			Detail: fmt.Sprintf("at least %d but no more than %d", r.MinChars, r.MaxChars)}
+	}
+	if r.list != nil && r.list.IsWeighted() {
+		return &RecipeError{Problem: ProblemUnsupported,
+			Detail: "MinChars and MaxChars can't be used with a weighted word list"}
+	}
+	if _, ok := r.separatorLength(); !ok {
+		return &RecipeError{Problem: ProblemUnsupported,
+			Detail: "MinChars and MaxChars need separators that are all the same length"}
+	}
+	return nil
+}
+
+// wordCounter counts and finds sequences of words from a list that
+// fit within bounds on their total number of characters
+type wordCounter struct {
+	wl  *WordList
+	fit [][]*big.Int // fit[k][c] is the number of ways to finish with k words after c characters
+}
+
+// newWordCounter sets up counting for r, which must have a word list and
+// separators of a fixed length
+func (r WLRecipe) newWordCounter() *wordCounter {
+	sepLen, _ := r.separatorLength()
+	seps := sepLen * (r.Length - 1)
+	wc := &wordCounter{wl: r.list}
+
+	// Bounds on the characters of the words alone. Nothing can be longer
+	// than Length of the longest words, so that will do for no maximum.
+	min, max := r.MinChars-seps, 0
+	if n := len(r.list.lengths); n > 0 {
+		max = r.list.lengths[n-1] * r.Length
+	}
+	if r.MaxChars > 0 && r.MaxChars-seps < max {
+		max = r.MaxChars - seps
+	}
+	top := max
+	if top < 0 {
+		top = -1 // nothing fits
+	}
+
+	// fit[0][c] is 1 if c characters are within bounds. Going backwards,
+	// fit[k][c] adds up the ways of choosing the next word.
+	wc.fit = make([][]*big.Int, r.Length+1)
+	for k := range wc.fit {
+		wc.fit[k] = make([]*big.Int, top+1)
+		for c := 0; c <= top; c++ {
+			n := new(big.Int)
+			if k == 0 {
+				if c >= min {
+					n.SetInt64(1)
+				}
+			} else {
+				for _, l := range r.list.lengths {
+					if c+l > top {
+						break
+					}
+					if f := wc.fit[k-1][c+l]; f.Sign() > 0 {
+						n.Add(n, new(big.Int).Mul(f, big.NewInt(int64(len(r.list.byLength[l])))))
+					}
+				}
+			}
+			wc.fit[k][c] = n
+		}
+	}
+	return wc
+}
+
+// total is the number of sequences of words that fit
+func (wc *wordCounter) total() *big.Int {
+	k := len(wc.fit) - 1
+	if len(wc.fit[k]) == 0 {
+		return new(big.Int)
+	}
+	return wc.fit[k][0]
+}
+
+// usable is the number of words on the list that appear in at least one
+// sequence that fits. Any word can go first, so a word of length l is usable
+// if the other words can finish a sequence from there.
+func (wc *wordCounter) usable() int {
+	k := len(wc.fit) - 2
+	if k < 0 {
+		return 0
+	}
+	n := 0
+	for _, l := range wc.wl.lengths {
+		if l < len(wc.fit[k]) && wc.fit[k][l].Sign() > 0 {
+			n += len(wc.wl.byLength[l])
+		}
+	}
+	return n
+}
+
+// unrank returns the idx-th sequence of words that fit. idx must be in [0, total())
+func (wc *wordCounter) unrank(idx *big.Int) ([]string, error) {
+	rem := new(big.Int).Set(idx)
+	picked := make([]string, 0, len(wc.fit)-1)
+	c := 0
+	for k := len(wc.fit) - 1; k > 0; k-- {
+		found := false
+		for _, l := range wc.wl.lengths {
+			if c+l >= len(wc.fit[k-1]) {
+				break
+			}
+			rest := wc.fit[k-1][c+l]
+			if rest.Sign() == 0 {
+				continue
+			}
+			list := wc.wl.byLength[l]
+			block := new(big.Int).Mul(rest, big.NewInt(int64(len(list))))
+			if rem.Cmp(block) >= 0 {
+				rem.Sub(rem, block)
+				continue
+			}
+			w, r := new(big.Int).QuoRem(rem, rest, new(big.Int))
+			picked = append(picked, list[w.Int64()])
+			rem = r
+			c += l
+			found = true
+			break
+		}
+		if !found {
+			return nil, errCounting("ran out of words with %d left", k)
+		}
+	}
+	return picked, nil
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
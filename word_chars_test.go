@@ -0,0 +1,162 @@
+package spg
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+	"unicode/utf8"
+)
+
+// bruteForceFits counts the sequences of length words from words that make
+// passwords of min to max characters with separators of sepLen characters
+func bruteForceFits(words []string, length, sepLen, min, max int) int {
+	count := 0
+	var walk func(k, chars int)
+	walk = func(k, chars int) {
+		if k == length {
+			chars += sepLen * (length - 1)
+			if chars >= min && (max == 0 || chars <= max) {
+				count++
+			}
+			return
+		}
+		for _, w := range words {
+			walk(k+1, chars+utf8.RuneCountInString(w))
+		}
+	}
+	walk(0, 0)
+	return count
+}
+
+func TestWLCharBounds(t *testing.T) {
+	words := []string{"a", "bb", "ccc", "dddd", "eeeee", "ff", "ég"}
+	wl, err := NewWordList(words)
+	if err != nil {
+		t.Fatalf("failed to create word list: %v", err)
+	}
+
+	vectors := []struct {
+		length, min, max int
+		sep              Separator
+	}{
+		{3, 7, 9, LiteralSeparator("-")},
+		{3, 0, 8, LiteralSeparator("")},
+		{4, 14, 0, LiteralSeparator("--")},
+		{2, 3, 4, NewCharSeparator(CharRecipe{Length: 1, Allow: Digits})},
+		{5, 1, 30, LiteralSeparator(" ")},
+	}
+	for i, v := range vectors {
+		r := NewWLRecipe(v.length, wl)
+		r.Separator = v.sep
+		r.MinChars, r.MaxChars = v.min, v.max
+		sepLen, _ := r.separatorLength()
+
+		want := bruteForceFits(words, v.length, sepLen, v.min, v.max)
+		wc := r.newWordCounter()
+		if got := wc.total(); got.Cmp(big.NewInt(int64(want))) != 0 {
+			t.Errorf("%d: expected %d passwords to fit, counted %v", i, want, got)
+			continue
+		}
+		wantEnt := log2Big(big.NewInt(int64(want))) + float32(v.length-1)*v.sep.Entropy()
+		if cmpFloat32(r.Entropy(), wantEnt, 100000) != 0 {
+			t.Errorf("%d: entropy should be %f, not %f", i, wantEnt, r.Entropy())
+		}
+
+		// Every position gives a different sequence of words that fits
+		seen := make(map[string]bool)
+		for j := 0; j < want; j++ {
+			ws, err := wc.unrank(big.NewInt(int64(j)))
+			if err != nil {
+				t.Fatalf("%d: couldn't unrank %d: %v", i, j, err)
+			}
+			key := ""
+			chars := sepLen * (v.length - 1)
+			for _, w := range ws {
+				key += w + " "
+				chars += utf8.RuneCountInString(w)
+			}
+			if seen[key] || chars < v.min || (v.max > 0 && chars > v.max) {
+				t.Errorf("%d: bad sequence %q at %d", i, key, j)
+			}
+			seen[key] = true
+		}
+
+		r.Source = newDetSource("chars")
+		for j := 0; j < 20; j++ {
+			p, err := r.Generate()
+			if err != nil {
+				t.Fatalf("%d: failed to generate: %v", i, err)
+			}
+			n := utf8.RuneCountInString(p.String())
+			if n < v.min || (v.max > 0 && n > v.max) || len(p.Tokens().Atoms()) != v.length {
+				t.Errorf("%d: %q doesn't fit", i, p)
+			}
+			if p.Entropy != r.Entropy() {
+				t.Errorf("%d: password entropy (%f) should be recipe entropy (%f)", i, p.Entropy, r.Entropy())
+			}
+		}
+	}
+}
+
+func TestWLCharBoundsErrors(t *testing.T) {
+	wl, err := NewWordList([]string{"one", "two", "three"})
+	if err != nil {
+		t.Fatalf("failed to create word list: %v", err)
+	}
+	r := NewWLRecipe(3, wl)
+	r.SeparatorChar = "-"
+
+	vectors := []struct {
+		min, max int
+		sf       SFFunction
+		problem  Problem
+	}{
+		{0, 10, nil, ProblemImpossible}, // 3 words of at least 3 letters, and 2 separators
+		{12, 10, nil, ProblemBadBounds},
+		{0, 20, SFDigits1, ProblemUnsupported},
+		{17, 17, nil, ProblemWordListTooSmall}, // Only "three-three-three" fits
+	}
+	for i, v := range vectors {
+		r.MinChars, r.MaxChars, r.SeparatorFunc = v.min, v.max, v.sf
+		var re *RecipeError
+		if err := r.Validate(); !errors.As(err, &re) || re.Problem != v.problem {
+			t.Errorf("%d: expected problem %d, got %v", i, v.problem, err)
+		}
+		if _, err := r.Generate(); !errors.As(err, &re) || re.Problem != v.problem {
+			t.Errorf("%d: Generate should fail with problem %d, got %v", i, v.problem, err)
+		}
+		if e := r.Entropy(); !math.IsNaN(float64(e)) {
+			t.Errorf("%d: entropy should be NaN, not %f", i, e)
+		}
+	}
+
+	r.MinChars, r.MaxChars, r.SeparatorFunc = 0, 11, nil
+	if err := r.Validate(); err != nil {
+		t.Errorf("recipe should be valid, got %v", err)
+	}
+	if _, err := r.Unrank(big.NewInt(0)); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("bounds on characters shouldn't be rankable, got %v", err)
+	}
+
+	// Bounds are saved
+	wl.Name = "numbers"
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("couldn't marshal: %v", err)
+	}
+	lists := func(string) (*WordList, error) { return wl, nil }
+	g, err := ParseRecipe(data, lists)
+	if err != nil {
+		t.Fatalf("couldn't parse %s: %v", data, err)
+	}
+	if back := g.(*WLRecipe); back.MaxChars != 11 || back.MinChars != 0 {
+		t.Errorf("%s came back as %+v", data, back)
+	}
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
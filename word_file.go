@@ -0,0 +1,168 @@
+package spg
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*** Reading word lists
+
+	Word lists are published in a few forms:
+
+		Plain lists are just words separated by white space, usually one
+		on each line.
+
+		Dice lists, such as Diceware and the EFF lists, put the dice rolls
+		that pick each word in front of it, as in "11111	abacus". Every
+		roll of the dice must pick exactly one word.
+
+	Either may have blank lines, and comment lines starting with "#".
+	The original Diceware list is also PGP signed, so the signature
+	wrapped around it is skipped.
+
+***/
+
+// WordListFormat is the form a word list was written in
+type WordListFormat string
+
+// The formats that ParseWordList understands
+const (
+	FormatPlain WordListFormat = "plain" // Words separated by white space, usually one on each line
+	FormatDice  WordListFormat = "dice"  // Words numbered by dice rolls, as on Diceware and EFF lists
+)
+
+// maxDice is the most dice a dice list can use; 6^12 words is about as
+// many as a WordList can hold
+const maxDice = 12
+
+const (
+	pgpBegin     = "-----BEGIN PGP SIGNED MESSAGE-----"
+	pgpSignature = "-----BEGIN PGP SIGNATURE-----"
+)
+
+// ParseWordList reads a word list from data, working out which format it is in.
+// A dice list must have a word for every roll of its dice.
+// The Format of the list returned says what was found.
+func ParseWordList(data []byte) (*WordList, error) {
+	lines := wordListLines(string(data))
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("%w: no words in word list", ErrTooFewWords)
+	}
+
+	var words []string
+	format := FormatPlain
+	if _, _, ok := splitDiceLine(lines[0].text); ok {
+		var err error
+		format = FormatDice
+		if words, err = parseDiceLines(lines); err != nil {
+			return nil, err
+		}
+	} else {
+		words = plainWords(lines)
+	}
+
+	wl, err := NewWordList(words)
+	if err != nil {
+		return nil, err
+	}
+	wl.Format = format
+	return wl, nil
+}
+
+// wordListLine is a line of a word list, with its line number for errors
+type wordListLine struct {
+	num  int
+	text string
+}
+
+// wordListLines returns the lines of a word list that aren't blank, comments,
+// or a PGP signature
+func wordListLines(s string) []wordListLine {
+	s = strings.TrimPrefix(s, "\ufeff") // byte order mark
+	signed := false
+	inHeader := false
+	var lines []wordListLine
+	for i, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == pgpBegin && len(lines) == 0 && !signed:
+			signed, inHeader = true, true
+			continue
+		case inHeader:
+			// Armor headers (such as "Hash: SHA1") end with a blank line
+			inHeader = line != ""
+			continue
+		case signed && line == pgpSignature:
+			return lines
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		}
+		if signed {
+			line = strings.TrimPrefix(line, "- ") // dash-escaping in signed text
+		}
+		lines = append(lines, wordListLine{i + 1, line})
+	}
+	return lines
+}
+
+// splitDiceLine splits a line of a dice list into its rolls and its word
+func splitDiceLine(line string) (string, string, bool) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 || strings.Trim(fields[0], "123456") != "" {
+		return "", "", false
+	}
+	return fields[0], fields[1], true
+}
+
+// parseDiceLines returns the words of a dice list, checking that every roll
+// of the dice picks exactly one of them
+func parseDiceLines(lines []wordListLine) ([]string, error) {
+	dice := 0
+	seen := make(map[string]bool, len(lines))
+	words := make([]string, 0, len(lines))
+	for _, l := range lines {
+		rolls, word, ok := splitDiceLine(l.text)
+		if !ok {
+			return nil, fmt.Errorf("%w: line %d of dice list is not dice rolls and a word: %q", ErrSyntax, l.num, l.text)
+		}
+		if dice == 0 {
+			dice = len(rolls)
+			if dice > maxDice {
+				return nil, fmt.Errorf("%w: dice list can't use more than %d dice", ErrTooManyWords, maxDice)
+			}
+		}
+		if len(rolls) != dice {
+			return nil, fmt.Errorf("%w: line %d of dice list has %d dice, not %d", ErrSyntax, l.num, len(rolls), dice)
+		}
+		if seen[rolls] {
+			return nil, fmt.Errorf("%w: line %d of dice list repeats the roll %s", ErrSyntax, l.num, rolls)
+		}
+		seen[rolls] = true
+		words = append(words, word)
+	}
+
+	// Each roll is different and uses the right dice, so counting them is enough
+	want := 1
+	for i := 0; i < dice; i++ {
+		want *= 6
+	}
+	if len(words) != want {
+		return nil, fmt.Errorf("%w: dice list for %d dice has %d of %d words", ErrTooFewWords, dice, len(words), want)
+	}
+	return words, nil
+}
+
+// plainWords returns the words of a plain list. Lines may have more than one
+// word on them, as opgen has always split plain lists on white space.
+func plainWords(lines []wordListLine) []string {
+	words := make([]string, 0, len(lines))
+	for _, l := range lines {
+		words = append(words, strings.Fields(l.text)...)
+	}
+	return words
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
@@ -0,0 +1,85 @@
+package spg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// diceList writes a dice list for the given number of dice, with a word
+// for every roll but those in skip
+func diceList(dice int, skip map[string]bool) string {
+	var b strings.Builder
+	var write func(rolls string)
+	write = func(rolls string) {
+		if len(rolls) == dice {
+			if !skip[rolls] {
+				fmt.Fprintf(&b, "%s\tw%s\n", rolls, rolls)
+			}
+			return
+		}
+		for d := '1'; d <= '6'; d++ {
+			write(rolls + string(d))
+		}
+	}
+	write("")
+	return b.String()
+}
+
+func TestParseWordList(t *testing.T) {
+	vectors := []struct {
+		data   string
+		format WordListFormat
+		size   int
+		first  string
+	}{
+		{"one\ntwo\n\nthree\n", FormatPlain, 3, "one"},
+		{"# My words\r\n#\r\nalpha\r\n  beta  \r\n# gamma\r\n", FormatPlain, 2, "alpha"},
+		{"\ufefffirst\nsecond", FormatPlain, 2, "first"},
+		{"one\ntwo words\n\tthree  four\n", FormatPlain, 5, "four"},
+		{diceList(5, nil), FormatDice, 7776, "w11111"},
+		{"# EFF short list\n" + diceList(4, nil), FormatDice, 1296, "w1111"},
+		{pgpBegin + "\nHash: SHA1\n\n" + diceList(2, nil) + pgpSignature + "\nVersion: 2.6.2\n\nabcdef\n-----END PGP SIGNATURE-----\n",
+			FormatDice, 36, "w11"},
+	}
+	for i, v := range vectors {
+		wl, err := ParseWordList([]byte(v.data))
+		if err != nil {
+			t.Errorf("%d: couldn't parse: %v", i, err)
+			continue
+		}
+		if wl.Format != v.format || int(wl.Size()) != v.size {
+			t.Errorf("%d: expected %d words in %s format, got %d in %s", i, v.size, v.format, wl.Size(), wl.Format)
+		}
+		if wl.words[0] != v.first {
+			t.Errorf("%d: expected %q first, got %q", i, v.first, wl.words[0])
+		}
+	}
+}
+
+func TestParseWordListErrors(t *testing.T) {
+	vectors := []struct {
+		data string
+		err  error
+	}{
+		{"", ErrTooFewWords},
+		{"# nothing but comments\n\n", ErrTooFewWords},
+		{diceList(5, map[string]bool{"34561": true}), ErrTooFewWords},
+		{diceList(3, nil) + "111\tagain\n", ErrSyntax},
+		{diceList(3, nil) + "1111\tlonger\n", ErrSyntax},
+		{diceList(2, nil) + "stray\n", ErrSyntax},
+		{"11\tone\n12 two three\n", ErrSyntax},
+		{strings.Repeat("1", maxDice+1) + " word\n", ErrTooManyWords},
+	}
+	for i, v := range vectors {
+		if _, err := ParseWordList([]byte(v.data)); !errors.Is(err, v.err) {
+			t.Errorf("%d: expected %v, got %v", i, v.err, err)
+		}
+	}
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
@@ -0,0 +1,82 @@
+package spg
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*** Deriving word lists
+
+	A smaller list can be made from an existing one by keeping only some of
+	its words. The new list is set up just as NewWordList would set it up,
+	so how many of its words can be capitalized, and so the entropy of
+	recipes that use it, reflect what is actually on it. Words from a
+	weighted list keep their weights.
+
+	A derived list has no Name. A saved recipe names the list it uses, and
+	the name of the list we started with would bring back all of its words.
+
+***/
+
+// Filter returns a new WordList with just the words of wl for which keep is true.
+// It is an error if that leaves no words.
+func (wl *WordList) Filter(keep func(word string) bool) (*WordList, error) {
+	var kept []string
+	for _, w := range wl.words {
+		if keep(w) {
+			kept = append(kept, w)
+		}
+	}
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("%w: no words were kept", ErrTooFewWords)
+	}
+	return wl.derive(kept)
+}
+
+// FilterLength returns a new WordList with just the words of wl that are
+// min to max characters long. A max of 0 means no maximum. Bounds that are
+// negative or out of order give ErrInvalidRecipe, since ErrBadLength is about
+// the length of passwords.
+func (wl *WordList) FilterLength(min, max int) (*WordList, error) {
+	if min < 0 || max < 0 || (max > 0 && min > max) {
+		return nil, fmt.Errorf("%w: word lengths from %d to %d", ErrInvalidRecipe, min, max)
+	}
+	var kept []string
+	for _, l := range wl.lengths {
+		if l >= min && (max == 0 || l <= max) {
+			kept = append(kept, wl.byLength[l]...)
+		}
+	}
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("%w: no words are %d to %d characters long", ErrTooFewWords, min, max)
+	}
+	return wl.derive(kept)
+}
+
+// derive returns a new WordList of words from wl, which keep their weights
+func (wl *WordList) derive(words []string) (*WordList, error) {
+	d, err := NewWordList(words)
+	if err != nil {
+		return nil, err
+	}
+	if wl.IsWeighted() {
+		d.setWeights(func(w string) uint32 { return wl.weightAt(wl.index[w]) })
+	}
+	return d, nil
+}
+
+// FilterAlphabet returns a new WordList with just the words of wl that are
+// made entirely of characters in abc. Note that capitalization may later
+// add characters that aren't in abc.
+func (wl *WordList) FilterAlphabet(abc string) (*WordList, error) {
+	return wl.Filter(func(w string) bool {
+		return strings.IndexFunc(w, func(c rune) bool {
+			return !strings.ContainsRune(abc, c)
+		}) < 0
+	})
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
@@ -0,0 +1,107 @@
+package spg
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestWLFilterLength(t *testing.T) {
+	wl, err := BuiltinWordList("words")
+	if err != nil {
+		t.Fatalf("no words: %v", err)
+	}
+	short, err := wl.FilterLength(4, 7)
+	if err != nil {
+		t.Fatalf("couldn't filter: %v", err)
+	}
+	if short.Name != "" {
+		t.Errorf("a filtered list shouldn't be called %q", short.Name)
+	}
+	if short.Size() == 0 || short.Size() >= wl.Size() {
+		t.Errorf("filtering %d words left %d", wl.Size(), short.Size())
+	}
+	want := 0
+	for _, w := range wl.words {
+		if n := utf8.RuneCountInString(w); n >= 4 && n <= 7 {
+			want++
+		}
+	}
+	if int(short.Size()) != want {
+		t.Errorf("expected %d words of 4 to 7 letters, got %d", want, short.Size())
+	}
+
+	r := NewWLRecipe(4, short)
+	expectedEnt := float32(4 * math.Log2(float64(want)))
+	if cmpFloat32(expectedEnt, r.Entropy(), entCompTolerance) != 0 {
+		t.Errorf("entropy should be %f, not %f", expectedEnt, r.Entropy())
+	}
+	r.Source = newDetSource("filter")
+	for i := 0; i < 20; i++ {
+		p, err := r.Generate()
+		if err != nil {
+			t.Fatalf("failed to generate: %v", err)
+		}
+		for _, w := range p.Tokens().Atoms() {
+			if n := utf8.RuneCountInString(w); n < 4 || n > 7 {
+				t.Errorf("%q has a word of %d letters", p, n)
+			}
+		}
+	}
+
+	if _, err := wl.FilterLength(7, 4); !errors.Is(err, ErrInvalidRecipe) || errors.Is(err, ErrBadLength) {
+		t.Errorf("lengths from 7 to 4 should be bad, got %v", err)
+	}
+	if _, err := wl.FilterLength(100, 0); !errors.Is(err, ErrTooFewWords) {
+		t.Errorf("no words should be that long, got %v", err)
+	}
+}
+
+func TestWLFilterAlphabet(t *testing.T) {
+	wl, err := NewWordList([]string{"4", "65", "one", "two", "three", "正確"})
+	if err != nil {
+		t.Fatalf("failed to create word list: %v", err)
+	}
+	if wl.isAllCapitalizable() {
+		t.Fatal("this test expects some words not to capitalize")
+	}
+
+	// Only words on a numeric keypad
+	keypad, err := wl.FilterAlphabet(ctDigits)
+	if err != nil {
+		t.Fatalf("couldn't filter: %v", err)
+	}
+	if keypad.Size() != 2 || keypad.unCapitalizableCount != 2 {
+		t.Errorf("expected 2 words that don't capitalize, got %v", keypad.words)
+	}
+
+	// Capitalization counts are worked out again for the new list
+	letters, err := wl.FilterAlphabet(ctLower)
+	if err != nil {
+		t.Fatalf("couldn't filter: %v", err)
+	}
+	if letters.Size() != 3 || !letters.isAllCapitalizable() {
+		t.Errorf("expected 3 words that capitalize, got %v", letters.words)
+	}
+	r := NewWLRecipe(3, letters)
+	r.Capitalize = CSOne
+	expectedEnt := float32(3*math.Log2(3) + math.Log2(3))
+	if cmpFloat32(expectedEnt, r.Entropy(), entCompTolerance) != 0 {
+		t.Errorf("entropy should be %f, not %f", expectedEnt, r.Entropy())
+	}
+
+	// Filter can keep anything
+	odd, err := wl.Filter(func(w string) bool { return len(w)%2 == 1 })
+	if err != nil || odd.Size() != 4 {
+		t.Errorf("expected 4 words with an odd number of bytes, got %v (%v)", odd, err)
+	}
+	if _, err := wl.FilterAlphabet("xyz"); !errors.Is(err, ErrTooFewWords) {
+		t.Errorf("no words are made of xyz, got %v", err)
+	}
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
@@ -3,7 +3,9 @@ package spg
 import (
 	"fmt"
 	"math"
+	"sort"
 	"strings"
+	"unicode/utf8"
 )
 
 // WLRecipe (Word List password Attributes) are the generator settings for wordlist (syllable list) passwords
@@ -12,7 +14,19 @@ type WLRecipe struct {
 	Length        int        // Length of generated password in words
 	SeparatorChar string     // What character(s) should separate words
 	SeparatorFunc SFFunction // function to generate separators, If nil just use SeperatorChar
+	Separator     Separator  // What separates words. If set, SeparatorChar and SeparatorFunc are ignored
 	Capitalize    CapScheme  // Which words in generated password should be capitalized
+
+	// Bounds on the length of passwords in characters, separators included.
+	// Zero means no bound. Passwords are picked uniformly from those that fit.
+	// Separators must all be the same length.
+	MinChars int
+	MaxChars int
+
+	// Where random bytes come from. If nil, crypto/rand is used.
+	// This includes separators from Separator, but those from SeparatorFunc
+	// are generated with their own source.
+	Source RandomSource
 }
 
 // CapScheme is for an enumeration of capitalization schemes
@@ -40,12 +54,26 @@ func NewWLRecipe(length int, wl *WordList) *WLRecipe {
 
 // WordList contains the list of words WLGenerator()
 type WordList struct {
+	Name   string         // Identifies the list in saved recipes
+	Format WordListFormat // How the list was written, if it was read with ParseWordList
+
 	words                []string
 	unCapitalizableCount int
+
+	index    map[string]int   // position of each word in words
+	titled   map[string]int   // position of each word, keyed by its capitalized form
+	byLength map[int][]string // words by their length in characters
+	lengths  []int            // the keys of byLength, in order
+
+	cumWeights []uint32 // running totals of the weights of words, if the list is weighted
+	maxWeight  uint32   // the largest weight
 }
 
 // Size of the wordlist in the recipe
 func (r WLRecipe) Size() uint32 {
+	if r.list == nil {
+		return 0
+	}
 	return r.list.Size()
 }
 
@@ -69,12 +97,12 @@ func (wl WordList) Size() uint32 {
 // as long as you need it.
 func NewWordList(list []string) (*WordList, error) {
 	if len(list) == 0 {
-		return nil, fmt.Errorf("cannot set up word list generator without words")
+		return nil, fmt.Errorf("%w: cannot set up word list generator without words", ErrTooFewWords)
 	}
 
 	// Our RNG for picking from a list returns a uint32, so that places an upper limit on size of list
 	if uint64(len(list)) > uint64(math.MaxUint32) {
-		return nil, fmt.Errorf("we can't handle more than %d words", uint32(0xFFFFFFFF))
+		return nil, fmt.Errorf("%w: we can't handle more than %d words", ErrTooManyWords, uint32(0xFFFFFFFF))
 	}
 
 	// We want to ensure that no item appears more than once
@@ -111,6 +139,9 @@ func NewWordList(list []string) (*WordList, error) {
 		ourWords = append(ourWords, w)
 
 	}
+	// Map iteration order is random, so we sort to make the list (and generation
+	// from a given RandomSource) reproducible
+	sort.Strings(ourWords)
 
 	if len(list) > len(ourWords) {
 		// We just need to log a warning here. Not sure how we are handling that.
@@ -122,38 +153,108 @@ func NewWordList(list []string) (*WordList, error) {
 		words:                ourWords,
 		unCapitalizableCount: unCapable,
 	}
+	result.indexWords()
 	return result, nil
 }
 
+// indexWords sets up the ways of finding words in wl
+func (wl *WordList) indexWords() {
+	wl.index = make(map[string]int, len(wl.words))
+	wl.titled = make(map[string]int, len(wl.words))
+	wl.byLength = make(map[int][]string)
+	wl.lengths = nil
+	for i, w := range wl.words {
+		wl.index[w] = i
+		wl.titled[strings.Title(w)] = i
+		l := utf8.RuneCountInString(w)
+		if wl.byLength[l] == nil {
+			wl.lengths = append(wl.lengths, l)
+		}
+		wl.byLength[l] = append(wl.byLength[l], w)
+	}
+	sort.Ints(wl.lengths)
+}
+
+// minUsableWords is the fewest words a recipe may pick from. With only one,
+// every password would be the same.
+const minUsableWords = 2
+
+// sizeError reports a word list with too few words that can be used.
+// With bounds on characters, wc is their counter, and only words that can
+// appear in a password that fits count. Otherwise wc is nil.
+func (r WLRecipe) sizeError(wc *wordCounter) error {
+	size := int(r.list.Size())
+	if wc != nil {
+		size = wc.usable()
+	}
+	if size < minUsableWords {
+		return &RecipeError{Problem: ProblemWordListTooSmall, Size: size, Need: minUsableWords}
+	}
+	return nil
+}
+
 // Generate a password using the wordlist recipe.
 func (r WLRecipe) Generate() (*Password, error) {
+	return r.GenerateFrom(r.Source)
+}
+
+// GenerateFrom is like Generate, but draws its randomness from rs
+// instead of from r.Source. A nil rs means crypto/rand.
+func (r WLRecipe) GenerateFrom(rs RandomSource) (*Password, error) {
 	p := &Password{}
 
-	if r.Size() == 0 {
-		return nil, fmt.Errorf("wordlist generator must be set up before being used")
+	if r.list == nil || r.Size() == 0 {
+		return nil, &RecipeError{Problem: ProblemNoWordList}
 	}
 	if r.Length < 1 {
-		return nil, fmt.Errorf("don't ask for passwords of length %d", r.Length)
+		return nil, &RecipeError{Problem: ProblemBadLength, Length: r.Length}
 	}
 
-	var sf SFFunction
-	if r.SeparatorFunc == nil {
-		sf = SFFunction(func() (string, FloatE) { return r.SeparatorChar, 0.0 })
-	} else {
-		sf = r.SeparatorFunc
+	// With bounds on characters, the words are picked all at once
+	var words []string
+	var wc *wordCounter
+	if r.limitsChars() {
+		if err := r.charsError(); err != nil {
+			return nil, err
+		}
+		wc = r.newWordCounter()
+		if wc.total().Sign() == 0 {
+			return nil, r.Validate()
+		}
+	}
+	if err := r.sizeError(wc); err != nil {
+		return nil, err
+	}
+	if wc != nil {
+		idx, err := randomBigIntn(rs, wc.total())
+		if err != nil {
+			return nil, err
+		}
+		if words, err = wc.unrank(idx); err != nil {
+			return nil, err
+		}
 	}
 
+	sf := r.separator()
+
 	// Construct a map of which words to capitalize
 	capWords := make(map[int]bool, r.Length)
 	switch r.Capitalize {
 	case CSFirst:
 		capWords[0] = true
 	case CSOne:
-		w := int(randomUint32n(uint32(r.Length)))
-		capWords[w] = true
+		w, err := randomUint32n(rs, uint32(r.Length))
+		if err != nil {
+			return nil, err
+		}
+		capWords[int(w)] = true
 	case CSRandom:
 		for i := 0; i < r.Length; i++ {
-			if randomUint32n(2) == 1 {
+			c, err := randomUint32n(rs, 2)
+			if err != nil {
+				return nil, err
+			}
+			if c == 1 {
 				capWords[i] = true
 			}
 		}
@@ -163,9 +264,17 @@ func (r WLRecipe) Generate() (*Password, error) {
 		}
 	}
 
-	ts := []Token{}
+	ts := make([]Token, 0, 2*r.Length-1)
 	for i := 0; i < r.Length; i++ {
-		w := r.list.words[randomUint32n(uint32(r.Size()))]
+		var w string
+		if words != nil {
+			w = words[i]
+		} else {
+			var err error
+			if w, err = r.list.pick(rs); err != nil {
+				return nil, err
+			}
+		}
 
 		if capWords[i] {
 			w = strings.Title(w)
@@ -174,14 +283,22 @@ func (r WLRecipe) Generate() (*Password, error) {
 			ts = append(ts, Token{w, AtomType})
 		}
 		if i < r.Length-1 {
-			sep, _ := sf()
+			sep, err := sf.Next(rs)
+			if err != nil {
+				return nil, err
+			}
 			if len(sep) > 0 {
 				ts = append(ts, Token{sep, SeparatorType})
 			}
 		}
 	}
 	p.tokens = ts
-	p.Entropy = r.Entropy()
+	if wc != nil {
+		// The counts are already in hand, so there is no need to redo them
+		p.Entropy = r.withExtraEntropy(FloatE(log2Big(wc.total())))
+	} else {
+		p.Entropy = r.Entropy()
+	}
 	return p, nil
 }
 
@@ -192,10 +309,55 @@ func (r WLRecipe) Generate() (*Password, error) {
 // and Shannon entropy are the same. If capitalization is used and the word list
 // contains members whose capitalization does not yield a distinct element,
 // the distribution becomes non-uniform.
+//
+// Words from a weighted list are not drawn uniformly either, and each
+// contributes its min-entropy. See ShannonEntropy.
 func (r WLRecipe) Entropy() float32 {
+	return r.entropy(false)
+}
+
+// ShannonEntropy is like Entropy, but counts words from a weighted list by
+// their Shannon entropy. It is more than Entropy when some words are more
+// likely than others, and is not a measure of how hard passwords are to guess.
+// Without weights it is the same as Entropy.
+func (r WLRecipe) ShannonEntropy() float32 {
+	return r.entropy(true)
+}
+
+// entropy does the work of Entropy and ShannonEntropy
+func (r WLRecipe) entropy(shannon bool) float32 {
+	if r.list == nil || r.Size() == 0 {
+		return float32(math.NaN())
+	}
 	size := int(r.Size())
 	ent := entropySimple(r.Length, size)
+	if r.list.IsWeighted() {
+		perWord := r.list.minEntropy()
+		if shannon {
+			perWord = r.list.shannonEntropy()
+		}
+		ent = FloatE(r.Length) * perWord
+	}
+	if r.limitsChars() {
+		if r.charsError() != nil || r.Length < 1 {
+			return float32(math.NaN())
+		}
+		wc := r.newWordCounter()
+		total := wc.total()
+		if total.Sign() == 0 || r.sizeError(wc) != nil {
+			return float32(math.NaN()) // Nothing, or next to nothing, fits
+		}
+		ent = FloatE(log2Big(total))
+	} else if r.sizeError(nil) != nil {
+		return float32(math.NaN())
+	}
+
+	return r.withExtraEntropy(ent)
+}
 
+// withExtraEntropy adds what capitalization and separators contribute
+// to ent, the entropy of the words alone
+func (r WLRecipe) withExtraEntropy(ent FloatE) float32 {
 	// Contribution of Capitalization scheme
 	if r.list.isAllCapitalizable() {
 		switch r.Capitalize {
@@ -209,10 +371,7 @@ func (r WLRecipe) Entropy() float32 {
 	// else there is no additional entropy contribution from capitalization
 
 	// Entropy contribution of separators
-	sepEnt := FloatE(0.0)
-	if r.SeparatorFunc != nil {
-		_, sepEnt = r.SeparatorFunc()
-	}
+	sepEnt := FloatE(r.separator().Entropy())
 	ent += (FloatE(r.Length) - 1.0) * sepEnt
 
 	return float32(ent)
@@ -239,41 +398,43 @@ func (wl *WordList) capitalizeRatio() float64 {
 ***/
 
 // SFFunction is a type for a function that returns a string
-// (to be used within a password) and the entropy it contributes
-type SFFunction func() (string, FloatE)
+// (to be used within a password) and the entropy it contributes,
+// or an error if it can't make one
+type SFFunction func() (string, FloatE, error)
 
 // NewSFFunction makes a Separator Function from a CharRecipe
 func NewSFFunction(r CharRecipe) SFFunction {
 
 	// I need to learn how to proper create factories.
 	var sf SFFunction
-	sf = func() (string, FloatE) { return sfWrap(r) }
+	sf = func() (string, FloatE, error) { return sfWrap(r) }
 	return sf
 }
 
 // Pre-baked Separator functions
 
-func sfWrap(r CharRecipe) (string, FloatE) {
+func sfWrap(r CharRecipe) (string, FloatE, error) {
 	p, err := r.Generate()
-	// perhaps not the best error handling, but can't think of anything better
 	if err != nil {
-		return "", 0.0
+		return "", 0.0, err
 	}
-	return p.String(), FloatE(p.Entropy)
+	return p.String(), FloatE(p.Entropy), nil
 }
 
 // SFNone empty separator
 // func SFNone() (string, FloatE) { return "", 0.0 }
 
-// Pre-baked Separator functions
+// Pre-baked Separator functions. Each has a Separator, such as SepDigits1
+// for SFDigits1, that should be used instead: as a SeparatorFunc these have
+// to generate a separator to learn its entropy.
 var (
-	SFNone               SFFunction = func() (string, FloatE) { return "", FloatE(0.0) }                      // Empty separator
-	SFDigits1                       = NewSFFunction(CharRecipe{Length: 1, Allow: Digits})                     // Single digit separator
-	SFDigits2                       = NewSFFunction(CharRecipe{Length: 2, Allow: Digits})                     // Double digit separator
-	SFDigitsNoAmbiguous1            = NewSFFunction(CharRecipe{Length: 1, Allow: Digits, Exclude: Ambiguous}) // Single digit, no ambiguous
-	SFDigitsNoAmbiguous2            = NewSFFunction(CharRecipe{Length: 2, Allow: Digits, Exclude: Ambiguous}) // Double digit, no ambiguous
-	SFSymbols                       = NewSFFunction(CharRecipe{Length: 1, Allow: Symbols})                    // Symbols
-	SFDigitsSymbols                 = NewSFFunction(CharRecipe{Length: 1, Allow: Symbols | Digits})           // Symbols and digits
+	SFNone               SFFunction = func() (string, FloatE, error) { return "", FloatE(0.0), nil } // Empty separator
+	SFDigits1                       = NewSFFunction(SepDigits1.Recipe)                               // Single digit separator
+	SFDigits2                       = NewSFFunction(SepDigits2.Recipe)                               // Double digit separator
+	SFDigitsNoAmbiguous1            = NewSFFunction(SepDigitsNoAmbiguous1.Recipe)                    // Single digit, no ambiguous
+	SFDigitsNoAmbiguous2            = NewSFFunction(SepDigitsNoAmbiguous2.Recipe)                    // Double digit, no ambiguous
+	SFSymbols                       = NewSFFunction(SepSymbols.Recipe)                               // Symbols
+	SFDigitsSymbols                 = NewSFFunction(SepDigitsSymbols.Recipe)                         // Symbols and digits
 )
 
 /**
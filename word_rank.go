@@ -0,0 +1,279 @@
+package spg
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+/*** Ranking word list passwords
+
+	A word list password is a sequence of Length choices of words, plus
+	whatever choices the separators and the capitalization scheme make. We
+	treat it as a big number written in a mixed radix: the word choices are
+	the digits (base Size()), followed by a digit for each separator made by
+	a CharRecipe (base the number of separators it can make, ranked as that
+	recipe ranks them), and the capitalization choice is the last digit.
+
+	This is only a bijection when each distinct choice gives a distinct
+	password. A separator from a CharRecipe may be mistaken for part of a
+	word, so those passwords are told apart by their tokens rather than by
+	their text. Other separators that aren't literal can't be ranked at all,
+	nor can random capitalization of a list in which some words don't change
+	on capitalization.
+
+***/
+
+// capSpace is the number of distinct capitalization choices made by the recipe
+func (r WLRecipe) capSpace() (*big.Int, error) {
+	switch r.Capitalize {
+	case CSRandom, CSOne:
+		if !r.list.isAllCapitalizable() {
+			return nil, fmt.Errorf("%w: capitalization scheme %q can't be ranked with words that don't capitalize", ErrUnsupported, r.Capitalize)
+		}
+		if r.Capitalize == CSOne {
+			return big.NewInt(int64(r.Length)), nil
+		}
+		return new(big.Int).Lsh(big.NewInt(1), uint(r.Length)), nil
+	default:
+		return big.NewInt(1), nil
+	}
+}
+
+// separatorWalker returns a walker over the separators that r can make,
+// or nil if its separator is literal
+func (r WLRecipe) separatorWalker() (*charWalker, error) {
+	var sr CharRecipe
+	switch s := r.separator().(type) {
+	case LiteralSeparator:
+		return nil, nil
+	case CharSeparator:
+		sr = s.Recipe
+	case *CharSeparator:
+		sr = s.Recipe
+	default:
+		return nil, fmt.Errorf("%w: passwords with separators that aren't literal or from a CharRecipe can't be ranked", ErrUnsupported)
+	}
+	if sr.Length < 1 || sr.runsError() != nil {
+		return nil, sr.Validate()
+	}
+	chars := sr.buildCharacterList()
+	if len(chars) == 0 {
+		return nil, sr.Validate()
+	}
+	if err := sr.overlapError(); err != nil {
+		return nil, err
+	}
+	w := newCharWalker(&sr, chars)
+	if w.total().Sign() == 0 {
+		return nil, sr.Validate()
+	}
+	return w, nil
+}
+
+// keyspace returns the number of distinct passwords the recipe can produce
+// along with the size of the capitalization part of that, and a walker
+// over the separators if they aren't literal
+func (r WLRecipe) keyspace() (n *big.Int, caps *big.Int, seps *charWalker, err error) {
+	if r.list == nil || r.Size() == 0 {
+		return nil, nil, nil, &RecipeError{Problem: ProblemNoWordList}
+	}
+	if r.Length < 1 {
+		return nil, nil, nil, &RecipeError{Problem: ProblemBadLength, Length: r.Length}
+	}
+	if r.limitsChars() {
+		return nil, nil, nil, fmt.Errorf("%w: passwords with bounds on characters can't be ranked", ErrUnsupported)
+	}
+	if err := r.sizeError(nil); err != nil {
+		return nil, nil, nil, err
+	}
+	if r.list.IsWeighted() {
+		return nil, nil, nil, fmt.Errorf("%w: passwords from weighted word lists can't be ranked", ErrUnsupported)
+	}
+	if seps, err = r.separatorWalker(); err != nil {
+		return nil, nil, nil, err
+	}
+	caps, err = r.capSpace()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	n = new(big.Int).Exp(big.NewInt(int64(r.Size())), big.NewInt(int64(r.Length)), nil)
+	if seps != nil {
+		n.Mul(n, new(big.Int).Exp(seps.total(), big.NewInt(int64(r.Length-1)), nil))
+	}
+	n.Mul(n, caps)
+	return n, caps, seps, nil
+}
+
+// Rank returns the position of p among all of the passwords that r can produce.
+// It is a number in [0, n) where n is the number of such passwords
+// (so 2^r.Entropy()). Unrank is its inverse.
+//
+// Separators can be literal or come from a CharSeparator. Recipes with other
+// separators, or with CSRandom or CSOne capitalization of a word list that has
+// words which don't capitalize, can't be ranked.
+func (r WLRecipe) Rank(p *Password) (*big.Int, error) {
+	_, caps, seps, err := r.keyspace()
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, fmt.Errorf("%w: nil password", ErrNotFromRecipe)
+	}
+	atoms := p.Tokens().Atoms()
+	if len(atoms) != r.Length {
+		return nil, fmt.Errorf("%w: password has %d words, recipe has %d", ErrNotFromRecipe, len(atoms), r.Length)
+	}
+
+	size := big.NewInt(int64(r.Size()))
+	idx := new(big.Int)
+	capped := make([]bool, r.Length)
+	for i, a := range atoms {
+		w, ok := r.list.index[a]
+		if !ok {
+			if w, ok = r.list.titled[a]; !ok {
+				return nil, fmt.Errorf("%w: %q is not on the word list", ErrNotFromRecipe, a)
+			}
+			capped[i] = true
+		}
+		idx.Mul(idx, size)
+		idx.Add(idx, big.NewInt(int64(w)))
+	}
+
+	// Separators from a CharRecipe come next, each ranked by that recipe
+	if seps != nil {
+		var sepTokens []Token
+		for _, t := range p.Tokens() {
+			if t.Type() == SeparatorType {
+				sepTokens = append(sepTokens, t)
+			}
+		}
+		if len(sepTokens) != r.Length-1 {
+			return nil, fmt.Errorf("%w: password has %d separators, recipe has %d", ErrNotFromRecipe, len(sepTokens), r.Length-1)
+		}
+		sepSize := seps.total()
+		for _, t := range sepTokens {
+			var chars Tokens
+			for _, c := range t.Value() {
+				chars = append(chars, Token{string(c), AtomType})
+			}
+			s, err := seps.rank(chars)
+			if err != nil {
+				return nil, err
+			}
+			idx.Mul(idx, sepSize)
+			idx.Add(idx, s)
+		}
+	}
+
+	capIdx := new(big.Int)
+	switch r.Capitalize {
+	case CSRandom:
+		for _, c := range capped {
+			capIdx.Lsh(capIdx, 1)
+			if c {
+				capIdx.SetBit(capIdx, 0, 1)
+			}
+		}
+	case CSOne:
+		for i, c := range capped {
+			if c {
+				capIdx.SetInt64(int64(i))
+				break
+			}
+		}
+	}
+	idx.Mul(idx, caps)
+	idx.Add(idx, capIdx)
+
+	// Anything that doesn't turn back into the same password
+	// (wrong separators, wrong capitalization) isn't one of ours
+	q, err := r.Unrank(idx)
+	if err != nil {
+		return nil, err
+	}
+	if q.String() != p.String() {
+		return nil, fmt.Errorf("%w: %q", ErrNotFromRecipe, p.String())
+	}
+	return idx, nil
+}
+
+// Unrank returns the idx-th password (counting from 0) of all of those r can produce.
+// It is the inverse of Rank, and can be used with the same recipes.
+func (r WLRecipe) Unrank(idx *big.Int) (*Password, error) {
+	n, caps, seps, err := r.keyspace()
+	if err != nil {
+		return nil, err
+	}
+	if idx == nil {
+		return nil, fmt.Errorf("%w: nil index", ErrIndexOutOfRange)
+	}
+	if idx.Sign() < 0 || idx.Cmp(n) >= 0 {
+		return nil, fmt.Errorf("%w: %v", ErrIndexOutOfRange, idx)
+	}
+
+	rest, capIdx := new(big.Int).QuoRem(idx, caps, new(big.Int))
+	capWords := make([]bool, r.Length)
+	switch r.Capitalize {
+	case CSFirst:
+		capWords[0] = true
+	case CSAll:
+		for i := range capWords {
+			capWords[i] = true
+		}
+	case CSOne:
+		capWords[capIdx.Int64()] = true
+	case CSRandom:
+		for i := range capWords {
+			capWords[i] = capIdx.Bit(r.Length-1-i) == 1
+		}
+	}
+
+	// Separators are the digits after the words, so they come off first
+	var sepStrings []string
+	if seps != nil {
+		sepSize := seps.total()
+		sepStrings = make([]string, r.Length-1)
+		digit := new(big.Int)
+		for i := r.Length - 2; i >= 0; i-- {
+			rest.QuoRem(rest, sepSize, digit)
+			chars, err := seps.unrank(digit)
+			if err != nil {
+				return nil, err
+			}
+			sepStrings[i] = Password{tokens: chars}.String()
+		}
+	}
+
+	// Word choices are digits in base Size(), most significant first
+	size := big.NewInt(int64(r.Size()))
+	words := make([]string, r.Length)
+	digit := new(big.Int)
+	for i := r.Length - 1; i >= 0; i-- {
+		rest.QuoRem(rest, size, digit)
+		w := r.list.words[digit.Int64()]
+		if capWords[i] {
+			w = strings.Title(w)
+		}
+		words[i] = w
+	}
+
+	sep, _ := r.literalSeparator()
+	ts := []Token{}
+	for i, w := range words {
+		if len(w) > 0 {
+			ts = append(ts, Token{w, AtomType})
+		}
+		if i < r.Length-1 && sepStrings != nil {
+			ts = append(ts, Token{sepStrings[i], SeparatorType})
+		} else if i < r.Length-1 && len(sep) > 0 {
+			ts = append(ts, Token{sep, SeparatorType})
+		}
+	}
+	return &Password{tokens: ts, Entropy: r.Entropy()}, nil
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
@@ -0,0 +1,162 @@
+package spg
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestWLRankAll(t *testing.T) {
+	wl, err := NewWordList([]string{"one", "two", "three"})
+	if err != nil {
+		t.Fatalf("failed to create word list: %v", err)
+	}
+	for _, cs := range []CapScheme{CSNone, CSFirst, CSAll, CSOne, CSRandom} {
+		r := NewWLRecipe(3, wl)
+		r.SeparatorChar = "-"
+		r.Capitalize = cs
+
+		n, _, _, err := r.keyspace()
+		if err != nil {
+			t.Fatalf("%s: no keyspace: %v", cs, err)
+		}
+		if cmpFloat(math.Log2(float64(n.Int64())), float64(r.Entropy()), entCompTolerance) != 0 {
+			t.Errorf("%s: keyspace %v doesn't match entropy %f", cs, n, r.Entropy())
+		}
+
+		seen := make(map[string]bool)
+		for i := int64(0); i < n.Int64(); i++ {
+			p, err := r.Unrank(big.NewInt(i))
+			if err != nil {
+				t.Fatalf("%s: couldn't unrank %d: %v", cs, i, err)
+			}
+			if seen[p.String()] {
+				t.Errorf("%s: %q appeared twice", cs, p)
+			}
+			seen[p.String()] = true
+
+			idx, err := r.Rank(p)
+			if err != nil {
+				t.Fatalf("%s: couldn't rank %q: %v", cs, p, err)
+			}
+			if idx.Int64() != i {
+				t.Errorf("%s: %q unranked from %d but ranked as %v", cs, p, i, idx)
+			}
+		}
+	}
+}
+
+func TestWLRankCharSeparator(t *testing.T) {
+	wl, err := NewWordList([]string{"one", "two", "3"})
+	if err != nil {
+		t.Fatalf("failed to create word list: %v", err)
+	}
+	seps := []Separator{
+		NewCharSeparator(CharRecipe{Length: 1, AllowChars: "34"}),
+		SepDigits1,
+		CharSeparator{Recipe: CharRecipe{Length: 2, Allow: Digits | Symbols, RequireSets: []string{"0123", "!"}, NoRepeat: true}},
+	}
+	for i, sep := range seps {
+		r := NewWLRecipe(3, wl)
+		r.Separator = sep
+
+		n, _, _, err := r.keyspace()
+		if err != nil {
+			t.Fatalf("%d: no keyspace: %v", i, err)
+		}
+		f, _ := new(big.Float).SetInt(n).Float64()
+		if cmpFloat(math.Log2(f), float64(r.Entropy()), entCompTolerance) != 0 {
+			t.Errorf("%d: keyspace %v doesn't match entropy %f", i, n, r.Entropy())
+		}
+
+		// Separators may look like words, so each password is
+		// checked by its tokens as well as by its text
+		for j := int64(0); j < n.Int64(); j += 7 {
+			p, err := r.Unrank(big.NewInt(j))
+			if err != nil {
+				t.Fatalf("%d: couldn't unrank %d: %v", i, j, err)
+			}
+			if len(p.Tokens().Atoms()) != 3 || len(p.Tokens()) != 5 {
+				t.Errorf("%d: %q should have 3 words and 2 separators", i, p)
+			}
+			idx, err := r.Rank(p)
+			if err != nil {
+				t.Fatalf("%d: couldn't rank %q: %v", i, p, err)
+			}
+			if idx.Int64() != j {
+				t.Errorf("%d: %q unranked from %d but ranked as %v", i, p, j, idx)
+			}
+		}
+
+		r.Source = newDetSource("seps")
+		p, err := r.Generate()
+		if err != nil {
+			t.Fatalf("%d: failed to generate: %v", i, err)
+		}
+		if _, err := r.Rank(p); err != nil {
+			t.Errorf("%d: couldn't rank generated %q: %v", i, p, err)
+		}
+	}
+
+	// Separators that the recipe can't make have no rank
+	r := NewWLRecipe(3, wl)
+	r.Separator = SepDigits1
+	p := &Password{tokens: Tokens{{"one", AtomType}, {"+", SeparatorType}, {"two", AtomType}, {"5", SeparatorType}, {"3", AtomType}}}
+	if _, err := r.Rank(p); !errors.Is(err, ErrNotFromRecipe) {
+		t.Errorf("%q has the wrong separators, and should have no rank, got %v", p, err)
+	}
+	p = &Password{tokens: Tokens{{"one", AtomType}, {"two", AtomType}, {"5", SeparatorType}, {"3", AtomType}}}
+	if _, err := r.Rank(p); !errors.Is(err, ErrNotFromRecipe) {
+		t.Errorf("%q is missing a separator, and should have no rank, got %v", p, err)
+	}
+}
+
+func TestWLRankEntropy(t *testing.T) {
+	wl, err := NewWordList(abSyllables)
+	if err != nil {
+		t.Fatalf("failed to create word list: %v", err)
+	}
+	r := NewWLRecipe(4, wl)
+	r.Capitalize = CSOne
+	r.SeparatorChar = " "
+
+	n, _, _, err := r.keyspace()
+	if err != nil {
+		t.Fatalf("no keyspace: %v", err)
+	}
+	f, _ := new(big.Float).SetInt(n).Float64()
+	if cmpFloat(math.Log2(f), float64(r.Entropy()), entCompTolerance) != 0 {
+		t.Errorf("keyspace %v doesn't match entropy %f", n, r.Entropy())
+	}
+}
+
+func TestWLRankUnrankable(t *testing.T) {
+	wl, err := NewWordList([]string{"one", "two", "4"})
+	if err != nil {
+		t.Fatalf("failed to create word list: %v", err)
+	}
+	r := NewWLRecipe(3, wl)
+	r.Capitalize = CSOne
+	if _, err := r.Unrank(big.NewInt(0)); err == nil {
+		t.Error("CSOne with words that don't capitalize should not be rankable")
+	}
+
+	r.Capitalize = CSNone
+	r.SeparatorFunc = SFDigits1
+	if _, err := r.Unrank(big.NewInt(0)); err == nil {
+		t.Error("recipe with a SeparatorFunc should not be rankable")
+	}
+
+	r.SeparatorFunc = nil
+	r.SeparatorChar = "-"
+	p := &Password{tokens: Tokens{{"one", AtomType}, {"+", SeparatorType}, {"two", AtomType}, {"+", SeparatorType}, {"4", AtomType}}}
+	if _, err := r.Rank(p); err == nil {
+		t.Errorf("%q has the wrong separators, and should have no rank", p)
+	}
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
@@ -0,0 +1,156 @@
+package spg
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+/*** Weighted word lists
+
+	A weighted list draws some words more often than others, in proportion
+	to their weights. A word with weight 3 is drawn as often as three
+	different words of weight 1 would be together.
+
+	Draws are no longer uniform, so the entropy reported is the min-entropy:
+	what an attacker who guesses the most likely words first faces. Each word
+	contributes log2(total weight / largest weight). The Shannon entropy,
+	which is larger, is available with ShannonEntropy, but should not be
+	taken as the strength of the passwords.
+
+***/
+
+// WeightedWord is an entry on a weighted word list
+type WeightedWord struct {
+	Word   string
+	Weight int // How often the word is drawn, relative to the others. Must be positive
+}
+
+// NewWeightedWordList creates a WordList from which each word is drawn in
+// proportion to its weight. Words that appear more than once, or that are
+// the capitalized form of another word, have their weights added together.
+// The weights may add up to no more than the maximum uint32.
+func NewWeightedWordList(entries []WeightedWord) (*WordList, error) {
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%w: cannot set up word list generator without words", ErrTooFewWords)
+	}
+
+	weights := make(map[string]uint64, len(entries))
+	var total uint64
+	for _, e := range entries {
+		if e.Weight < 1 {
+			return nil, fmt.Errorf("%w: %q has weight %d", ErrBadWeight, e.Word, e.Weight)
+		}
+		weights[e.Word] += uint64(e.Weight)
+		total += uint64(e.Weight)
+		if total > uint64(math.MaxUint32) {
+			return nil, fmt.Errorf("%w: weights can't add up to more than %d", ErrTooManyWords, uint32(math.MaxUint32))
+		}
+	}
+
+	// NewWordList keeps "polish" over "Polish", so that is where the weight goes
+	for w := range weights {
+		if cap := strings.Title(w); cap != w {
+			if cw, ok := weights[cap]; ok {
+				weights[w] += cw
+				delete(weights, cap)
+			}
+		}
+	}
+
+	words := make([]string, 0, len(weights))
+	for w := range weights {
+		words = append(words, w)
+	}
+	wl, err := NewWordList(words)
+	if err != nil {
+		return nil, err
+	}
+	wl.setWeights(func(w string) uint32 { return uint32(weights[w]) })
+	return wl, nil
+}
+
+// setWeights gives each word of wl the weight that weight returns for it
+func (wl *WordList) setWeights(weight func(word string) uint32) {
+	wl.cumWeights = make([]uint32, len(wl.words))
+	wl.maxWeight = 0
+	var sum uint32
+	for i, w := range wl.words {
+		n := weight(w)
+		if n > wl.maxWeight {
+			wl.maxWeight = n
+		}
+		sum += n
+		wl.cumWeights[i] = sum
+	}
+}
+
+// IsWeighted is true if words are drawn from wl in proportion to their weights
+func (wl *WordList) IsWeighted() bool {
+	return wl.cumWeights != nil
+}
+
+// Weight returns the weight of word on wl, which is 1 for every word of a list
+// without weights, and 0 if the word isn't on the list
+func (wl *WordList) Weight(word string) int {
+	i, ok := wl.index[word]
+	if !ok {
+		return 0
+	}
+	if !wl.IsWeighted() {
+		return 1
+	}
+	return int(wl.weightAt(i))
+}
+
+// weightAt is the weight of the i-th word of a weighted list
+func (wl *WordList) weightAt(i int) uint32 {
+	if i == 0 {
+		return wl.cumWeights[0]
+	}
+	return wl.cumWeights[i] - wl.cumWeights[i-1]
+}
+
+// totalWeight is the sum of the weights of a weighted list
+func (wl *WordList) totalWeight() uint32 {
+	return wl.cumWeights[len(wl.cumWeights)-1]
+}
+
+// pick draws a word from wl, in proportion to its weight if it has one
+func (wl *WordList) pick(rs RandomSource) (string, error) {
+	if !wl.IsWeighted() {
+		j, err := randomUint32n(rs, wl.Size())
+		if err != nil {
+			return "", err
+		}
+		return wl.words[j], nil
+	}
+	n, err := randomUint32n(rs, wl.totalWeight())
+	if err != nil {
+		return "", err
+	}
+	i := sort.Search(len(wl.cumWeights), func(i int) bool { return wl.cumWeights[i] > n })
+	return wl.words[i], nil
+}
+
+// minEntropy is the min-entropy of a word drawn from a weighted list
+func (wl *WordList) minEntropy() FloatE {
+	return FloatE(math.Log2(float64(wl.totalWeight()) / float64(wl.maxWeight)))
+}
+
+// shannonEntropy is the Shannon entropy of a word drawn from a weighted list
+func (wl *WordList) shannonEntropy() FloatE {
+	total := float64(wl.totalWeight())
+	ent := 0.0
+	for i := range wl.words {
+		p := float64(wl.weightAt(i)) / total
+		ent -= p * math.Log2(p)
+	}
+	return FloatE(ent)
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/
@@ -0,0 +1,114 @@
+package spg
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestWeightedWordList(t *testing.T) {
+	wl, err := NewWeightedWordList([]WeightedWord{{"one", 1}, {"two", 1}, {"three", 2}})
+	if err != nil {
+		t.Fatalf("couldn't create weighted list: %v", err)
+	}
+	if !wl.IsWeighted() || wl.Size() != 3 || wl.Weight("three") != 2 || wl.Weight("four") != 0 {
+		t.Errorf("list is wrong: %v %v", wl.words, wl.cumWeights)
+	}
+
+	// The most likely word has probability 1/2, so one bit for each word,
+	// while Shannon entropy is 1/4*2 + 1/4*2 + 1/2*1 for each word
+	r := NewWLRecipe(3, wl)
+	if cmpFloat32(r.Entropy(), 3.0, entCompTolerance) != 0 {
+		t.Errorf("min-entropy should be 3, not %f", r.Entropy())
+	}
+	if cmpFloat32(r.ShannonEntropy(), 4.5, entCompTolerance) != 0 {
+		t.Errorf("Shannon entropy should be 4.5, not %f", r.ShannonEntropy())
+	}
+
+	// Words are drawn in proportion to their weights
+	r.Length = 1
+	r.Source = newDetSource("weighted")
+	counts := make(map[string]int)
+	trials := 4000
+	for i := 0; i < trials; i++ {
+		p, err := r.Generate()
+		if err != nil {
+			t.Fatalf("failed to generate: %v", err)
+		}
+		if p.Entropy != r.Entropy() {
+			t.Errorf("password has entropy %f, recipe %f", p.Entropy, r.Entropy())
+		}
+		counts[p.String()]++
+	}
+	for w, want := range map[string]float64{"one": 0.25, "two": 0.25, "three": 0.5} {
+		if got := float64(counts[w]) / float64(trials); math.Abs(got-want) > 0.03 {
+			t.Errorf("%q drawn %.3f of the time, expected %.3f", w, got, want)
+		}
+	}
+
+	// Equal weights are the same as no weights
+	plain, _ := NewWordList([]string{"one", "two", "three"})
+	even, _ := NewWeightedWordList([]WeightedWord{{"one", 5}, {"two", 5}, {"three", 5}})
+	for _, cs := range []CapScheme{CSNone, CSOne, CSRandom} {
+		a, b := NewWLRecipe(4, plain), NewWLRecipe(4, even)
+		a.Capitalize, b.Capitalize = cs, cs
+		if cmpFloat32(a.Entropy(), b.Entropy(), entCompTolerance) != 0 ||
+			cmpFloat32(b.Entropy(), b.ShannonEntropy(), entCompTolerance) != 0 {
+			t.Errorf("%s: entropy %f without weights, %f (Shannon %f) with", cs, a.Entropy(), b.Entropy(), b.ShannonEntropy())
+		}
+	}
+
+	// Repeats add up, as do capitalized forms
+	merged, err := NewWeightedWordList([]WeightedWord{{"polish", 1}, {"Polish", 2}, {"polish", 1}, {"wax", 4}})
+	if err != nil {
+		t.Fatalf("couldn't create weighted list: %v", err)
+	}
+	if merged.Size() != 2 || merged.Weight("polish") != 4 || merged.Weight("Polish") != 0 {
+		t.Errorf("expected polish with weight 4, got %v %v", merged.words, merged.cumWeights)
+	}
+
+	// Filtering keeps weights
+	short, err := wl.FilterLength(0, 3)
+	if err != nil {
+		t.Fatalf("couldn't filter: %v", err)
+	}
+	if !short.IsWeighted() || short.Weight("one") != 1 || short.Size() != 2 {
+		t.Errorf("filtered list lost its weights: %v %v", short.words, short.cumWeights)
+	}
+}
+
+func TestWeightedWordListErrors(t *testing.T) {
+	vectors := []struct {
+		entries []WeightedWord
+		err     error
+	}{
+		{nil, ErrTooFewWords},
+		{[]WeightedWord{{"one", 1}, {"two", 0}}, ErrBadWeight},
+		{[]WeightedWord{{"one", 1}, {"two", -3}}, ErrBadWeight},
+		{[]WeightedWord{{"one", math.MaxInt32}, {"two", math.MaxInt32}, {"three", math.MaxInt32}}, ErrTooManyWords},
+	}
+	for i, v := range vectors {
+		if _, err := NewWeightedWordList(v.entries); !errors.Is(err, v.err) {
+			t.Errorf("%d: expected %v, got %v", i, v.err, err)
+		}
+	}
+
+	wl, err := NewWeightedWordList([]WeightedWord{{"one", 1}, {"two", 2}, {"three", 3}})
+	if err != nil {
+		t.Fatalf("couldn't create weighted list: %v", err)
+	}
+	r := NewWLRecipe(3, wl)
+	if _, err := r.Unrank(big.NewInt(0)); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("weighted lists shouldn't be rankable, got %v", err)
+	}
+	r.MaxChars = 20
+	if _, err := r.Generate(); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("bounds on characters shouldn't work with weights, got %v", err)
+	}
+}
+
+/**
+ ** Copyright 2018 AgileBits, Inc.
+ ** Licensed under the Apache License, Version 2.0 (the "License").
+ **/